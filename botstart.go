@@ -1,21 +1,40 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/ilyalavrinov/tgbot-betterthanpbelov/mybot"
 )
 
-const cfg_filename = "mybot.cfg"
-
 func main() {
+	cfgFilename := flag.String("config", "mybot.cfg", "configuration file; any field can be overridden by BOT_<SECTION>_<FIELD> environment variable")
+	checkConfig := flag.Bool("check-config", false, "validate configuration and exit")
+	flag.Parse()
+
+	if *checkConfig {
+		explicitConfig := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "config" {
+				explicitConfig = true
+			}
+		})
+		if err := mybot.CheckConfig(*cfgFilename, explicitConfig); err != nil {
+			log.Printf("Configuration is invalid: %s", err)
+			os.Exit(1)
+		}
+		log.Print("Configuration is valid")
+		return
+	}
+
 	rand.Seed(time.Now().UTC().UnixNano())
 
 	log.Print("Starting my bot")
 
-	err := mybot.Start(cfg_filename)
+	err := mybot.Start(*cfgFilename)
 	if err != nil {
 		log.Printf("My bot could not be started due to error: %s", err)
 	}
@@ -0,0 +1,132 @@
+// Package citylist reads city lists published by OpenWeatherMap (city.list.json and its extended variants)
+package citylist
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultRedisPrefix starts keys of the city index written by the city parser and read by the bot
+const DefaultRedisPrefix = "openweathermap"
+
+// City is a single record of the city list
+type City struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	State   string `json:"state"`
+	Country string `json:"country"`
+	Coord   struct {
+		Lon float64 `json:"lon"`
+		Lat float64 `json:"lat"`
+	} `json:"coord"`
+	// both fields below are present only in extended lists like current.city.list.json
+	Langs []map[string]string `json:"langs"`
+	Stat  struct {
+		Population int64 `json:"population"`
+	} `json:"stat"`
+}
+
+// Names returns all lowercase names of the city including alternate/localized ones
+func (c City) Names() []string {
+	seen := map[string]bool{}
+	result := make([]string, 0, 1+len(c.Langs))
+	add := func(name string) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+
+	add(c.Name)
+	for _, lang := range c.Langs {
+		for code, name := range lang {
+			if code == "link" || code == "post" { // not names but URL and post code
+				continue
+			}
+			add(name)
+		}
+	}
+	return result
+}
+
+// Open opens the city list, transparently decompressing .gz files
+func Open(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: gz, f: f}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// Read decodes the city list one record at a time, calling f for each of them
+func Read(r io.Reader, f func(City) error) error {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return fmt.Errorf("city list should be a json array, got token %v, error: %v", t, err)
+	}
+	for dec.More() {
+		var city City
+		if err := dec.Decode(&city); err != nil {
+			return err
+		}
+		if err := f(city); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// NameOwner is the city which a name resolves to
+type NameOwner struct {
+	ID         int64
+	Population int64
+}
+
+// NameResolver maps every name to a single city ID; bigger cities win name collisions
+type NameResolver struct {
+	Owners     map[string]NameOwner
+	Collisions int
+}
+
+func NewNameResolver() *NameResolver {
+	return &NameResolver{Owners: make(map[string]NameOwner, 250000)}
+}
+
+func (r *NameResolver) Add(city City) {
+	for _, name := range city.Names() {
+		owner, found := r.Owners[name]
+		if found {
+			r.Collisions++
+			if owner.Population >= city.Stat.Population {
+				continue
+			}
+		}
+		r.Owners[name] = NameOwner{ID: city.ID, Population: city.Stat.Population}
+	}
+}
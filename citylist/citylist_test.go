@@ -0,0 +1,45 @@
+package citylist
+
+import "testing"
+import "reflect"
+
+func testCity(id int64, name string, population int64, langs ...map[string]string) City {
+	city := City{ID: id, Name: name, Langs: langs}
+	city.Stat.Population = population
+	return city
+}
+
+func TestNames(t *testing.T) {
+	city := testCity(1, " Kazan ", 0,
+		map[string]string{"ru": "Казань"},
+		map[string]string{"en": "kazan"},
+		map[string]string{"link": "https://en.wikipedia.org/wiki/Kazan"},
+		map[string]string{"post": "420000"})
+	if names := city.Names(); !reflect.DeepEqual(names, []string{"kazan", "казань"}) {
+		t.Fatal(names)
+	}
+}
+
+func TestNameResolverPopulationWins(t *testing.T) {
+	cases := []struct {
+		cities   []City
+		expected int64
+	}{
+		{[]City{testCity(1, "Kazan", 100), testCity(2, "Kazan", 1000)}, 2},
+		{[]City{testCity(2, "Kazan", 1000), testCity(1, "Kazan", 100)}, 2},
+		{[]City{testCity(1, "Kazan", 100), testCity(2, "Kazan", 100)}, 1}, // the first one keeps the name on a tie
+		{[]City{testCity(1, "Kazan", 0), testCity(2, "Kasan", 5, map[string]string{"de": "Kazan"})}, 2},
+	}
+	for i, c := range cases {
+		r := NewNameResolver()
+		for _, city := range c.cities {
+			r.Add(city)
+		}
+		if owner := r.Owners["kazan"]; owner.ID != c.expected {
+			t.Errorf("case %d: resolved to %d, expected %d", i, owner.ID, c.expected)
+		}
+		if r.Collisions != 1 {
+			t.Errorf("case %d: collisions %d", i, r.Collisions)
+		}
+	}
+}
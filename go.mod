@@ -27,3 +27,6 @@ require (
 	gopkg.in/telegram-bot-api.v4 v4.6.4
 	gopkg.in/warnings.v0 v0.1.2 // indirect
 )
+
+// local copy of the bot base, so that it can be extended together with the bot
+replace github.com/admirallarimda/tgbotbase => ./tgbotbase
@@ -1,32 +1,238 @@
 package mybot
 
+import "fmt"
 import "github.com/admirallarimda/tgbotbase"
+import cmd "github.com/ilyalavrinov/tgbot-betterthanpbelov/mybot/commandhandler"
 import "gopkg.in/gcfg.v1"
 import "log"
+import "os"
+import "reflect"
+import "strconv"
+import "strings"
+import "time"
+
+const defaultShutdownTimeout = 30 * time.Second
+
+// envPrefix starts names of variables overriding the config file: BOT_<SECTION>_<FIELD>, e.g. BOT_TGBOT_TOKEN
+const envPrefix = "BOT_"
 
 type Config struct {
 	tgbotbase.Config
 	Redis   tgbotbase.RedisConfig
 	Weather struct {
-		Token string
+		Token         string
+		Air_Provider  string // 'openweathermap' (default) or 'none'
+		Air_URL       string // base URL of the air quality API, provider default if empty
+		Cities        string // where city names are resolved: 'redis' (default) or 'file'
+		Cities_File   string // city.list.json or city.list.json.gz from OpenWeatherMap for 'file'
+		Cities_Prefix string // key prefix the city parser has been run with for 'redis', its default if empty
+
+		History_Sampling string // how often weather in subscribed cities is recorded for stats, e.g. 1h; off if empty
+	}
+
+	Scheduler struct {
+		Missed_Grace string // how late a daily job missed due to restart may still be delivered, e.g. 2h
 	}
 
 	Owners struct {
 		ID []string
 	}
+
+	Shutdown struct {
+		Timeout string // how long running jobs and queued replies are waited for on stop, e.g. 30s
+	}
 }
 
+// String hides every token and password; it has to be updated when a secret is added to the config
+func (cfg Config) String() string {
+	weather := cfg.Weather
+	weather.Token = tgbotbase.MaskSecret(weather.Token)
+	return fmt.Sprintf("{%s Redis:%s Weather:%+v Scheduler:%+v Owners:%+v Shutdown:%+v}",
+		cfg.Config, cfg.Redis, weather, cfg.Scheduler, cfg.Owners, cfg.Shutdown)
+}
+
+// NewConfig reads the file and applies overrides from environment; the file may be absent if everything is set via environment
 func NewConfig(filename string) (Config, error) {
 	log.Printf("Reading configuration from: %s", filename)
 
 	var cfg Config
 
 	err := gcfg.ReadFileInto(&cfg, filename)
-	if err != nil {
+	if os.IsNotExist(err) {
+		log.Printf("Configuration file %s does not exist, only environment is used", filename)
+	} else if err != nil {
 		log.Printf("Could not correctly parse configuration file: %s; error: %s", filename, err)
 		return cfg, err
 	}
 
+	overridden, err := applyEnvOverrides(&cfg, os.LookupEnv)
+	if err != nil {
+		log.Printf("Could not apply configuration from environment, error: %s", err)
+		return cfg, err
+	}
+	if len(overridden) > 0 {
+		log.Printf("Configuration has been overridden from environment: %s", strings.Join(overridden, ", "))
+	}
+
 	log.Printf("Configuration has been successfully read from %s: %+v", filename, cfg)
 	return cfg, nil
 }
+
+// CheckConfig reads the configuration the same way Start does and validates it;
+// fileRequired makes a missing file an error, e.g. when it has been given explicitly
+func CheckConfig(filename string, fileRequired bool) error {
+	if fileRequired {
+		if _, err := os.Stat(filename); err != nil {
+			return err
+		}
+	}
+	cfg, err := NewConfig(filename)
+	if err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// Validate checks values which would otherwise fail on start; nothing is connected to
+func (cfg Config) Validate() error {
+	if cfg.TGBot.Token == "" && !cfg.TGBot.SkipConnect {
+		return fmt.Errorf("[tgbot] token is not set")
+	}
+	if cfg.Redis.Server == "" {
+		return fmt.Errorf("[redis] server is not set")
+	}
+	if _, err := cfg.missedGrace(); err != nil {
+		return err
+	}
+	if _, err := cfg.shutdownTimeout(); err != nil {
+		return err
+	}
+	if _, err := cfg.historySampling(); err != nil {
+		return err
+	}
+	if _, err := cmd.NewAirQualityProvider(cfg.Weather.Air_Provider, cfg.Weather.Air_URL, cfg.Weather.Token); err != nil {
+		return err
+	}
+	switch strings.ToLower(cfg.Weather.Cities) {
+	case "", "redis":
+	case "file":
+		if _, err := os.Stat(cfg.Weather.Cities_File); err != nil {
+			return fmt.Errorf("[weather] cities-file is not accessible: %s", err)
+		}
+		if cfg.Weather.History_Sampling != "" {
+			return fmt.Errorf("[weather] history-sampling needs weather history, which is kept only with cities = redis")
+		}
+	default:
+		return fmt.Errorf("[weather] cities has unknown value '%s'", cfg.Weather.Cities)
+	}
+	return nil
+}
+
+// citiesFromFile tells whether cities are loaded from a file, so that 'openweathermap' Redis DB is not used
+func (cfg Config) citiesFromFile() bool {
+	return strings.ToLower(cfg.Weather.Cities) == "file"
+}
+
+func (cfg Config) missedGrace() (time.Duration, error) {
+	if cfg.Scheduler.Missed_Grace == "" {
+		return 0, nil
+	}
+	grace, err := time.ParseDuration(cfg.Scheduler.Missed_Grace)
+	if err != nil {
+		return 0, fmt.Errorf("[scheduler] missed-grace '%s' is not a duration", cfg.Scheduler.Missed_Grace)
+	}
+	return grace, nil
+}
+
+func (cfg Config) historySampling() (time.Duration, error) {
+	if cfg.Weather.History_Sampling == "" {
+		return 0, nil
+	}
+	sampling, err := time.ParseDuration(cfg.Weather.History_Sampling)
+	if err != nil || sampling < 0 {
+		return 0, fmt.Errorf("[weather] history-sampling '%s' is not a valid duration", cfg.Weather.History_Sampling)
+	}
+	return sampling, nil
+}
+
+func (cfg Config) shutdownTimeout() (time.Duration, error) {
+	if cfg.Shutdown.Timeout == "" {
+		return defaultShutdownTimeout, nil
+	}
+	timeout, err := time.ParseDuration(cfg.Shutdown.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("[shutdown] timeout '%s' is not a duration", cfg.Shutdown.Timeout)
+	}
+	return timeout, nil
+}
+
+// applyEnvOverrides sets every field which has BOT_<SECTION>_<FIELD> variable and returns names of the variables used.
+// Sections of embedded configs are treated as own ones, so the token is BOT_TGBOT_TOKEN
+func applyEnvOverrides(cfg *Config, lookup func(string) (string, bool)) ([]string, error) {
+	return overrideSections(reflect.ValueOf(cfg).Elem(), lookup)
+}
+
+func overrideSections(cfg reflect.Value, lookup func(string) (string, bool)) ([]string, error) {
+	overridden := make([]string, 0)
+	for i := 0; i < cfg.NumField(); i++ {
+		sectionType := cfg.Type().Field(i)
+		section := cfg.Field(i)
+		if sectionType.Anonymous {
+			names, err := overrideSections(section, lookup)
+			overridden = append(overridden, names...)
+			if err != nil {
+				return overridden, err
+			}
+			continue
+		}
+		if section.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.NumField(); j++ {
+			name := envPrefix + strings.ToUpper(sectionType.Name+"_"+section.Type().Field(j).Name)
+			value, found := lookup(name)
+			if !found {
+				continue
+			}
+			if err := setConfigField(section.Field(j), value); err != nil {
+				return overridden, fmt.Errorf("%s: %s", name, err)
+			}
+			overridden = append(overridden, name)
+		}
+	}
+	return overridden, nil
+}
+
+// setConfigField parses the value the way gcfg does; multi-valued variables are comma-separated
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 0, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", value)
+		}
+		field.SetInt(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported field type %s", field.Type())
+		}
+		values := make([]string, 0)
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package mybot
+
+import "testing"
+import "reflect"
+import "os"
+
+func testLookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, found := env[name]
+		return value, found
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	var cfg Config
+	cfg.Weather.Token = "from file"
+	cfg.Redis.Server = "file:6379"
+	env := map[string]string{
+		"BOT_TGBOT_TOKEN":            "123:abc",
+		"BOT_TGBOT_SKIPCONNECT":      "true",
+		"BOT_WEATHER_TOKEN":          "from env",
+		"BOT_OWNERS_ID":              " alice, 42 ,,",
+		"BOT_SCHEDULER_MISSED_GRACE": "2h",
+		"BOT_UNKNOWN_FIELD":          "ignored"}
+
+	overridden, err := applyEnvOverrides(&cfg, testLookup(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overridden) != len(env)-1 {
+		t.Errorf("overridden %v", overridden)
+	}
+	if cfg.TGBot.Token != "123:abc" || !cfg.TGBot.SkipConnect {
+		t.Errorf("embedded section: %+v", cfg.TGBot)
+	}
+	if cfg.Weather.Token != "from env" {
+		t.Errorf("weather token: %s", cfg.Weather.Token)
+	}
+	if cfg.Redis.Server != "file:6379" {
+		t.Errorf("not overridden field has changed: %s", cfg.Redis.Server)
+	}
+	if !reflect.DeepEqual(cfg.Owners.ID, []string{"alice", "42"}) {
+		t.Errorf("owners: %#v", cfg.Owners.ID)
+	}
+	if cfg.Scheduler.Missed_Grace != "2h" {
+		t.Errorf("missed grace: %s", cfg.Scheduler.Missed_Grace)
+	}
+}
+
+func TestApplyEnvOverridesInvalid(t *testing.T) {
+	var cfg Config
+	_, err := applyEnvOverrides(&cfg, testLookup(map[string]string{"BOT_TGBOT_SKIPCONNECT": "maybe"}))
+	if err == nil {
+		t.Fatal("invalid boolean has been accepted")
+	}
+}
+
+func TestSetConfigField(t *testing.T) {
+	var target struct {
+		S   string
+		B   bool
+		I   int
+		L   []string
+		Map map[string]string
+	}
+	v := reflect.ValueOf(&target).Elem()
+
+	cases := []struct {
+		field string
+		value string
+		fails bool
+	}{
+		{"S", "text", false},
+		{"B", "1", false},
+		{"B", "yes", true},
+		{"I", "0x10", false},
+		{"I", "ten", true},
+		{"L", "a,b", false},
+		{"Map", "a=b", true},
+	}
+	for _, c := range cases {
+		err := setConfigField(v.FieldByName(c.field), c.value)
+		if (err != nil) != c.fails {
+			t.Errorf("%s=%s: unexpected error %v", c.field, c.value, err)
+		}
+	}
+	if target.S != "text" || !target.B || target.I != 16 || !reflect.DeepEqual(target.L, []string{"a", "b"}) {
+		t.Errorf("%+v", target)
+	}
+}
+
+func TestCheckConfigMissingFile(t *testing.T) {
+	const missing = "no-such-file.cfg"
+	os.Setenv("BOT_TGBOT_TOKEN", "123:abc")
+	os.Setenv("BOT_REDIS_SERVER", "localhost:6379")
+	defer os.Unsetenv("BOT_TGBOT_TOKEN")
+	defer os.Unsetenv("BOT_REDIS_SERVER")
+
+	if err := CheckConfig(missing, false); err != nil {
+		t.Errorf("configuration from environment only: %s", err)
+	}
+	if err := CheckConfig(missing, true); err == nil {
+		t.Error("missing required file has been accepted")
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const adminUsage = `Команды владельцев:
+/admin status - аптайм, обработчики, задачи, подписки
+/admin broadcast <текст> - разослать текст во все чаты с подписками
+/admin reload - перечитать владельцев из конфига и расписания из хранилища, остальное применяется только после перезапуска
+/admin stop - остановить бота`
+
+// BotStatus is the part of tgbotbase.Bot shown in /admin status
+type BotStatus interface {
+	HandlerNames() []string
+}
+
+type adminHandler struct {
+	tgbotbase.BaseHandler
+	bot         BotStatus
+	cron        tgbotbase.Cron
+	storage     tgbotbase.PropertyStorage
+	permissions *Permissions
+	reload      func() error
+	started     time.Time
+}
+
+var _ tgbotbase.IncomingMessageHandler = &adminHandler{}
+
+func NewAdminHandler(bot BotStatus, cron tgbotbase.Cron, storage tgbotbase.PropertyStorage, permissions *Permissions, reload func() error) *adminHandler {
+	return &adminHandler{
+		bot:         bot,
+		cron:        cron,
+		storage:     storage,
+		permissions: permissions,
+		reload:      reload,
+		started:     time.Now()}
+}
+
+func (h *adminHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	h.SrvCh = srvCh
+	return tgbotbase.NewHandlerTrigger(nil, []string{"admin"})
+}
+
+func (h *adminHandler) Name() string {
+	return "Admin"
+}
+
+func (h *adminHandler) HandleOne(msg tgbotapi.Message) {
+	fields := log.Fields{"user": msg.From.ID, "username": msg.From.UserName, "chat": msg.Chat.ID}
+	if !h.permissions.IsOwner(msg.From) {
+		log.WithFields(fields).Warn("Admin command from non-owner")
+		h.reply(msg, "Команда доступна только владельцам бота")
+		return
+	}
+
+	args := strings.TrimSpace(msg.CommandArguments())
+	subcommand := args
+	rest := ""
+	if i := strings.IndexAny(args, " \n"); i >= 0 {
+		subcommand = args[:i]
+		rest = strings.TrimSpace(args[i+1:])
+	}
+	log.WithFields(fields).WithField("subcommand", subcommand).Info("Admin command")
+
+	switch strings.ToLower(subcommand) {
+	case "status":
+		h.reply(msg, h.status())
+	case "broadcast":
+		if rest == "" {
+			h.reply(msg, "Нужен текст: /admin broadcast <текст>")
+			return
+		}
+		h.reply(msg, h.broadcast(rest))
+	case "reload":
+		if err := h.reload(); err != nil {
+			log.WithFields(fields).WithError(err).Error("Reload has failed")
+			h.reply(msg, fmt.Sprintf("Не удалось перезагрузить: %s", err))
+			return
+		}
+		h.reply(msg, "Перечитал владельцев из конфига и расписания из хранилища. Остальные поля конфига применятся только после перезапуска")
+	case "stop":
+		h.reply(msg, "Останавливаюсь")
+		requestStop(h.SrvCh)
+	default:
+		h.reply(msg, adminUsage)
+	}
+}
+
+func (h *adminHandler) reply(msg tgbotapi.Message, text string) {
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.BaseChat.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
+
+func (h *adminHandler) status() string {
+	lines := []string{
+		fmt.Sprintf("Аптайм: %s", time.Since(h.started).Truncate(time.Second)),
+		fmt.Sprintf("Обработчики: %s", strings.Join(h.bot.HandlerNames(), ", "))}
+	if cronStatus, ok := h.cron.(tgbotbase.CronStatus); ok {
+		lines = append(lines, fmt.Sprintf("Запланированных задач: %d", cronStatus.ScheduledJobs()))
+	}
+
+	subscriptions, err := subscribedChats(h.storage)
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("Подписки: ошибка %s", err))
+		return strings.Join(lines, "\n")
+	}
+	counts := make([]string, 0, len(settingsFeatures))
+	for _, f := range settingsFeatures {
+		counts = append(counts, fmt.Sprintf("%s %d", f.property, len(subscriptions[f.property])))
+	}
+	lines = append(lines, fmt.Sprintf("Подписки: %s", strings.Join(counts, ", ")))
+	return strings.Join(lines, "\n")
+}
+
+func (h *adminHandler) broadcast(text string) string {
+	subscriptions, err := subscribedChats(h.storage)
+	if err != nil {
+		return fmt.Sprintf("Не удалось получить список чатов: %s", err)
+	}
+	chats := make(map[tgbotbase.ChatID]bool)
+	for _, featureChats := range subscriptions {
+		for _, chat := range featureChats {
+			chats[chat] = true
+		}
+	}
+	ids := make([]tgbotbase.ChatID, 0, len(chats))
+	for chat := range chats {
+		ids = append(ids, chat)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, chat := range ids {
+		h.OutMsgCh <- tgbotapi.NewMessage(int64(chat), text)
+	}
+	log.WithFields(log.Fields{"chats": len(ids)}).Info("Broadcast has been sent")
+	return fmt.Sprintf("Разослано в %d чатов", len(ids))
+}
+
+// subscribedChats returns chats having chat-level daily subscriptions grouped by property
+func subscribedChats(storage tgbotbase.PropertyStorage) (map[string][]tgbotbase.ChatID, error) {
+	result := make(map[string][]tgbotbase.ChatID, len(settingsFeatures))
+	for _, f := range settingsFeatures {
+		props, err := storage.GetEveryHavingProperty(f.property)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range props {
+			if p.Value == "" || (p.User != 0 && tgbotbase.ChatID(p.User) != p.Chat) {
+				continue
+			}
+			result[f.property] = append(result[f.property], p.Chat)
+		}
+	}
+	return result, nil
+}
+
+// requestStop asks the bot to stop; the message is sent asynchronously as the main cycle may be waiting for the calling handler to take the next message
+func requestStop(srvCh chan<- tgbotbase.ServiceMsg) {
+	go func() {
+		srvCh <- tgbotbase.NewStopMsg()
+	}()
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+var reAir = regexp.MustCompile("^(воздух|air)([^\\pL\\pN]|$)")
+
+// AirQuality is a provider-independent report about air pollution and UV
+type AirQuality struct {
+	AQI   int     // 1 (good) - 5 (very poor)
+	PM2_5 float64 // μg/m³
+	PM10  float64 // μg/m³
+	UV    *float64
+}
+
+// AirQualityProvider is a source of air quality data for a location
+type AirQualityProvider interface {
+	AirQuality(lat, lon float64) (*AirQuality, error)
+}
+
+const (
+	airProviderOpenWeatherMap = "openweathermap"
+	airProviderNone           = "none"
+
+	owmDefaultURL = "http://api.openweathermap.org/data/2.5"
+	// UV index is only available via One Call API 3.0, which needs its own subscription
+	owmDefaultOneCallURL = "http://api.openweathermap.org/data/3.0"
+)
+
+// NewAirQualityProvider creates a provider by its name from config; nil provider is returned for 'none'.
+// A custom baseURL is used for both air pollution and One Call requests
+func NewAirQualityProvider(name string, baseURL string, token string) (AirQualityProvider, error) {
+	switch strings.ToLower(name) {
+	case "", airProviderOpenWeatherMap:
+		oneCallURL := baseURL
+		if baseURL == "" {
+			baseURL = owmDefaultURL
+			oneCallURL = owmDefaultOneCallURL
+		}
+		return &owmAirQualityProvider{
+			baseURL:    strings.TrimRight(baseURL, "/"),
+			oneCallURL: strings.TrimRight(oneCallURL, "/"),
+			token:      token}, nil
+	case airProviderNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown air quality provider '%s'", name)
+}
+
+type owmAirQualityProvider struct {
+	baseURL    string
+	oneCallURL string
+	token      string
+}
+
+var _ AirQualityProvider = &owmAirQualityProvider{}
+
+type owmAirPollutionData struct {
+	List []struct {
+		Main struct {
+			Aqi int
+		}
+		Components struct {
+			Pm2_5 float64
+			Pm10  float64
+		}
+	}
+}
+
+type owmOneCallData struct {
+	Current struct {
+		Uvi *float64
+	}
+}
+
+func (p *owmAirQualityProvider) AirQuality(lat, lon float64) (*AirQuality, error) {
+	bytes, err := requestURL(fmt.Sprintf("%s/air_pollution?lat=%f&lon=%f&APPID=%s", p.baseURL, lat, lon, p.token))
+	if err != nil {
+		return nil, err
+	}
+	pollution := owmAirPollutionData{}
+	if err = json.Unmarshal(bytes, &pollution); err != nil {
+		return nil, newWeatherError(weatherErrMalformed, err)
+	}
+	if len(pollution.List) == 0 {
+		return nil, newWeatherError(weatherErrMalformed, errors.New("empty air pollution list"))
+	}
+
+	result := &AirQuality{
+		AQI:   pollution.List[0].Main.Aqi,
+		PM2_5: pollution.List[0].Components.Pm2_5,
+		PM10:  pollution.List[0].Components.Pm10}
+
+	// UV is optional, air quality is still useful without it, e.g. if there is no One Call subscription
+	bytes, err = requestURL(fmt.Sprintf("%s/onecall?lat=%f&lon=%f&exclude=minutely,hourly,daily,alerts&APPID=%s", p.oneCallURL, lat, lon, p.token))
+	if err == nil {
+		oneCall := owmOneCallData{}
+		if err = json.Unmarshal(bytes, &oneCall); err == nil {
+			result.UV = oneCall.Current.Uvi
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"lat": lat, "lon": lon}).WithError(err).Warn("Could not get UV index")
+	}
+
+	return result, nil
+}
+
+var aqiDescriptions = []string{"нет данных", "хороший", "удовлетворительный", "умеренный", "плохой", "очень плохой"}
+
+func describeAQI(aqi int) string {
+	if aqi < 0 || aqi >= len(aqiDescriptions) {
+		return aqiDescriptions[0]
+	}
+	return aqiDescriptions[aqi]
+}
+
+func describeUV(uv float64) string {
+	switch {
+	case uv < 3:
+		return "низкий"
+	case uv < 6:
+		return "умеренный"
+	case uv < 8:
+		return "высокий"
+	case uv < 11:
+		return "очень высокий"
+	}
+	return "экстремальный"
+}
+
+func formatAirQuality(city string, air *AirQuality) string {
+	text := fmt.Sprintf("Воздух в %s: %s (AQI %d), PM2.5 %.1f мкг/м³, PM10 %.1f мкг/м³", city,
+		describeAQI(air.AQI), air.AQI, air.PM2_5, air.PM10)
+	if air.UV != nil {
+		text += fmt.Sprintf(", УФ-индекс %.1f (%s)", *air.UV, describeUV(*air.UV))
+	}
+	return text
+}
+
+// getAirQuality takes coordinates of the city from the index and requests air quality for them.
+// Current weather is requested for coordinates only if the index has none, e.g. it has been written by an old city parser
+func getAirQuality(provider AirQualityProvider, cities CityIndex, token string, cityId int64) (string, error) {
+	if provider == nil {
+		return "", errors.New("air quality provider is not configured")
+	}
+
+	city, err := cities.CityInfo(cityId)
+	if weatherErrorKindOf(err) == weatherErrUnknownCity {
+		data, err := requestCurrentWeather(token, cityId, defaultWeatherOptions)
+		if err != nil {
+			return "", err
+		}
+		city = CityInfo{Name: data.Name, Lat: data.Coord.Lat, Lon: data.Coord.Lon}
+	} else if err != nil {
+		return "", err
+	}
+
+	air, err := provider.AirQuality(city.Lat, city.Lon)
+	if err != nil {
+		return "", err
+	}
+	return formatAirQuality(city.Name, air), nil
+}
+
+func (h *weatherHandler) handleAir(msg tgbotapi.Message) {
+	var replyText string
+	if h.air == nil {
+		replyText = "Не знаю, где узнавать про воздух: источник данных не настроен"
+	} else if cityID, err := h.determineCity(msg); err != nil {
+		logWeatherError(err, log.Fields{"text": msg.Text, "chat": msg.Chat.ID})
+		replyText = weatherErrorReply(err)
+	} else if replyText, err = getAirQuality(h.air, h.cities, h.token, cityID); err != nil {
+		logWeatherError(err, log.Fields{"text": msg.Text, "chat": msg.Chat.ID, "city": cityID})
+		replyText = weatherErrorReply(err)
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, replyText)
+	reply.BaseChat.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+}
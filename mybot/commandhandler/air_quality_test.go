@@ -0,0 +1,65 @@
+package cmd
+
+import "testing"
+import "fmt"
+import "strings"
+import "net/http"
+import "net/http/httptest"
+
+func TestFormatAirQuality(t *testing.T) {
+	uv := 6.5
+	cases := []struct {
+		air      AirQuality
+		expected string
+	}{
+		{AirQuality{AQI: 2, PM2_5: 7.25, PM10: 12},
+			"Воздух в Казань: удовлетворительный (AQI 2), PM2.5 7.2 мкг/м³, PM10 12.0 мкг/м³"},
+		{AirQuality{AQI: 4, PM2_5: 60, PM10: 80, UV: &uv},
+			"Воздух в Казань: плохой (AQI 4), PM2.5 60.0 мкг/м³, PM10 80.0 мкг/м³, УФ-индекс 6.5 (высокий)"},
+		{AirQuality{AQI: 9},
+			"Воздух в Казань: нет данных (AQI 9), PM2.5 0.0 мкг/м³, PM10 0.0 мкг/м³"},
+	}
+	for _, c := range cases {
+		if text := formatAirQuality("Казань", &c.air); text != c.expected {
+			t.Errorf("%+v: %s", c.air, text)
+		}
+	}
+}
+
+func TestOWMAirQualityProvider(t *testing.T) {
+	oneCallStatus := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/air_pollution"):
+			fmt.Fprint(w, `{"list":[{"main":{"aqi":3},"components":{"pm2_5":20.5,"pm10":31}}]}`)
+		case strings.HasSuffix(r.URL.Path, "/onecall"):
+			w.WriteHeader(oneCallStatus)
+			fmt.Fprint(w, `{"current":{"uvi":2.4}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewAirQualityProvider(airProviderOpenWeatherMap, server.URL, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	air, err := provider.AirQuality(55.79, 49.12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if air.AQI != 3 || air.PM2_5 != 20.5 || air.PM10 != 31 || air.UV == nil || *air.UV != 2.4 {
+		t.Errorf("%+v", air)
+	}
+
+	// no One Call subscription
+	oneCallStatus = http.StatusUnauthorized
+	air, err = provider.AirQuality(55.79, 49.12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if air.AQI != 3 || air.UV != nil {
+		t.Errorf("%+v", air)
+	}
+}
@@ -1,29 +1,22 @@
 package cmd
 
-import "log"
-import "regexp"
-import "strings"
+import "time"
+import log "github.com/sirupsen/logrus"
+import "github.com/admirallarimda/tgbotbase"
 
-func msgMatches(text string, patterns []string) bool {
-	compiledRegExp := make([]*regexp.Regexp, 0, len(patterns))
-	for _, pattern := range patterns {
-		re, err := regexp.Compile(pattern)
-		if err != nil {
-			log.Printf("Pattern %s cannot be compiled into a regexp. Error: %s", pattern, err)
-			continue
-		}
-		compiledRegExp = append(compiledRegExp, re)
-	}
+// chatLevel is the user to read chat-level properties with: in a private chat they may be set by its user, whose ID is the chat ID,
+// while in groups no user has such ID, so the chat-wide value is found
+func chatLevel(chat tgbotbase.ChatID) tgbotbase.UserID {
+	return tgbotbase.UserID(chat)
+}
 
-	msgWords := strings.Split(text, " ")
-	for _, word := range msgWords {
-		for _, re := range compiledRegExp {
-			if re.MatchString(strings.ToLower(word)) {
-				log.Printf("Word %s matched regexp %s", word, re)
-				return true
-			}
-		}
+// inUserTimezone converts time into the timezone set via 'timezone' property; time is returned unchanged if the property is not set or invalid
+func inUserTimezone(props tgbotbase.PropertyStorage, user tgbotbase.UserID, chat tgbotbase.ChatID, t time.Time) time.Time {
+	tz, _ := props.GetProperty("timezone", user, chat)
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Printf("Could not load timezone %s correctly; location loaded with error: %s", tz, err)
+		return t
 	}
-	log.Printf("None of the words in text: %s; matched patterns %s", text, patterns)
-	return false
+	return t.In(loc)
 }
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/go-redis/redis"
+	"github.com/ilyalavrinov/tgbot-betterthanpbelov/citylist"
+	log "github.com/sirupsen/logrus"
+)
+
+// CityIndex resolves lowercase city names into OpenWeatherMap city IDs
+type CityIndex interface {
+	CityID(name string) (int64, error)
+	CityInfo(id int64) (CityInfo, error)
+}
+
+// CityInfo is what the index keeps about a city besides its ID
+type CityInfo struct {
+	Name     string
+	Lat, Lon float64
+}
+
+const (
+	cityIndexRedis = "redis"
+	cityIndexFile  = "file"
+)
+
+// NewCityIndex creates an index by its kind from config: 'redis' (filled by the city parser tool with the same key prefix) or 'file' (city list loaded into memory)
+func NewCityIndex(kind string, file string, prefix string, pool tgbotbase.RedisPool) (CityIndex, error) {
+	switch strings.ToLower(kind) {
+	case "", cityIndexRedis:
+		return NewRedisCityIndex(pool, prefix), nil
+	case cityIndexFile:
+		return NewFileCityIndex(file)
+	}
+	return nil, fmt.Errorf("unknown city index kind '%s'", kind)
+}
+
+type redisCityIndex struct {
+	conn   *redis.Client
+	prefix string
+}
+
+var _ CityIndex = &redisCityIndex{}
+
+// NewRedisCityIndex reads the index written by the city parser; empty prefix means the parser's default one
+func NewRedisCityIndex(pool tgbotbase.RedisPool, prefix string) CityIndex {
+	if prefix == "" {
+		prefix = citylist.DefaultRedisPrefix
+	}
+	return &redisCityIndex{conn: pool.GetConnByName("openweathermap"), prefix: prefix}
+}
+
+// keyPrefix returns the prefix of the active city index version; keys without version are used if the index is not versioned
+func (i *redisCityIndex) keyPrefix() string {
+	version, err := i.conn.Get(i.prefix + ":city-version").Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("Could not get active city index version, error: %s", err)
+		}
+		return i.prefix
+	}
+	return fmt.Sprintf("%s:v%s", i.prefix, version)
+}
+
+func (i *redisCityIndex) CityID(name string) (int64, error) {
+	key := fmt.Sprintf("%s:city:%s", i.keyPrefix(), name)
+	result := i.conn.HGet(key, "id")
+	if result.Err() != nil {
+		log.Printf("Could not HGet for key '%s', error: %s", key, result.Err())
+		if result.Err() == redis.Nil {
+			return 0, newWeatherError(weatherErrUnknownCity, fmt.Errorf("no city '%s' in the index", name))
+		}
+		return 0, newWeatherError(weatherErrNetwork, result.Err())
+	}
+
+	cityId, err := result.Int64()
+	if err != nil {
+		log.Printf("Could not convert ID for key '%s' into int, error: %s", key, err)
+		return 0, newWeatherError(weatherErrMalformed, err)
+	}
+	return cityId, nil
+}
+
+// CityInfo takes the name from the city hash and coordinates from the geo set, both written by the city parser
+func (i *redisCityIndex) CityInfo(id int64) (CityInfo, error) {
+	prefix := i.keyPrefix()
+	member := fmt.Sprintf("%d", id)
+	pipe := i.conn.Pipeline()
+	name := pipe.HGet(fmt.Sprintf("%s:cityid:%d", prefix, id), "name")
+	pos := pipe.GeoPos(fmt.Sprintf("%s:citygeo", prefix), member)
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		log.Printf("Could not get info of city %d, error: %s", id, err)
+		return CityInfo{}, newWeatherError(weatherErrNetwork, err)
+	}
+	positions := pos.Val()
+	if name.Err() != nil || len(positions) == 0 || positions[0] == nil {
+		return CityInfo{}, newWeatherError(weatherErrUnknownCity, fmt.Errorf("no coordinates of city %d in the index", id))
+	}
+	return CityInfo{Name: name.Val(), Lat: positions[0].Latitude, Lon: positions[0].Longitude}, nil
+}
+
+// memoryCityIndex keeps names sorted for binary search with IDs in a parallel slice
+type memoryCityIndex struct {
+	names []string
+	ids   []int64
+	info  map[int64]CityInfo
+}
+
+var _ CityIndex = &memoryCityIndex{}
+
+// NewFileCityIndex loads city list from a .json or .json.gz file into memory
+func NewFileCityIndex(path string) (CityIndex, error) {
+	log.Printf("Loading cities from %s", path)
+	in, err := citylist.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	resolver := citylist.NewNameResolver()
+	info := make(map[int64]CityInfo, 250000)
+	err = citylist.Read(in, func(city citylist.City) error {
+		resolver.Add(city)
+		info[city.ID] = CityInfo{Name: city.Name, Lat: city.Coord.Lat, Lon: city.Coord.Lon}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resolver.Owners) == 0 {
+		return nil, fmt.Errorf("no cities in %s", path)
+	}
+
+	index := &memoryCityIndex{
+		names: make([]string, 0, len(resolver.Owners)),
+		ids:   make([]int64, len(resolver.Owners)),
+		info:  info}
+	for name := range resolver.Owners {
+		index.names = append(index.names, name)
+	}
+	sort.Strings(index.names)
+	for i, name := range index.names {
+		index.ids[i] = resolver.Owners[name].ID
+	}
+	log.Printf("Loaded %d city names from %s", len(index.names), path)
+	return index, nil
+}
+
+func (i *memoryCityIndex) CityID(name string) (int64, error) {
+	pos := sort.SearchStrings(i.names, name)
+	if pos == len(i.names) || i.names[pos] != name {
+		return 0, newWeatherError(weatherErrUnknownCity, fmt.Errorf("no city '%s' in the index", name))
+	}
+	return i.ids[pos], nil
+}
+
+func (i *memoryCityIndex) CityInfo(id int64) (CityInfo, error) {
+	info, found := i.info[id]
+	if !found {
+		return CityInfo{}, newWeatherError(weatherErrUnknownCity, fmt.Errorf("no city %d in the index", id))
+	}
+	return info, nil
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import "testing"
+import "compress/gzip"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+
+const testCityList = "testdata/city.list.json"
+
+// gzipCityList writes a compressed copy of the fixture into dir
+func gzipCityList(t *testing.T, dir string) string {
+	data, err := ioutil.ReadFile(testCityList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "city.list.json.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileCityIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{testCityList, gzipCityList(t, dir)} {
+		index, err := NewFileCityIndex(path)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+
+		cases := []struct {
+			name string
+			id   int64
+		}{
+			{"kazan", 551487}, // the bigger city wins the name
+			{"казань", 551487},
+			{"казан", 551487},
+			{"москва", 524901},
+			{"moscow", 524901},
+		}
+		for _, c := range cases {
+			id, err := index.CityID(c.name)
+			if err != nil || id != c.id {
+				t.Errorf("%s: %s resolved to %d, error %v", path, c.name, id, err)
+			}
+		}
+		for _, notName := range []string{"101000", "https://en.wikipedia.org/wiki/kazan", "omsk"} {
+			if _, err := index.CityID(notName); weatherErrorKindOf(err) != weatherErrUnknownCity {
+				t.Errorf("%s: %s has been resolved, error %v", path, notName, err)
+			}
+		}
+
+		info, err := index.CityInfo(524901)
+		if err != nil || info.Name != "Moscow" || info.Lat != 55.75222 || info.Lon != 37.61556 {
+			t.Errorf("%s: %+v, error %v", path, info, err)
+		}
+		if _, err := index.CityInfo(2); weatherErrorKindOf(err) != weatherErrUnknownCity {
+			t.Errorf("%s: unknown city info error %v", path, err)
+		}
+	}
+}
+
+func TestFileCityIndexErrors(t *testing.T) {
+	if _, err := NewFileCityIndex("testdata/no-such-file.json"); err == nil {
+		t.Error("missing file has been loaded")
+	}
+
+	f, err := ioutil.TempFile("", "cities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[]")
+	f.Close()
+	if _, err := NewFileCityIndex(f.Name()); err == nil {
+		t.Error("empty list has been loaded")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gocolly/colly"
@@ -47,18 +48,28 @@ type covid19Handler struct {
 
 	updates chan covidData
 	toSend  chan tgbotbase.ChatID
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{} // closed once the update loop has exited
+
+	chatsMutex sync.Mutex
+	chats      map[tgbotbase.ChatID]bool
 }
 
-var _ tgbotbase.BackgroundMessageHandler = &covid19Handler{}
+var _ tgbotbase.DrainableBackgroundHandler = &covid19Handler{}
 
 func NewCovid19Handler(cron tgbotbase.Cron,
-	props tgbotbase.PropertyStorage) tgbotbase.BackgroundMessageHandler {
+	props tgbotbase.PropertyStorage) *covid19Handler {
 	h := &covid19Handler{
 		props: props,
 		cron:  cron,
 
 		updates: make(chan covidData, 0),
 		toSend:  make(chan tgbotbase.ChatID, 0),
+		chats:   make(map[tgbotbase.ChatID]bool),
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
 	}
 	return h
 }
@@ -68,14 +79,13 @@ func (h *covid19Handler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- t
 }
 
 func (h *covid19Handler) Run() {
-	chatsToNotify := make([]tgbotbase.ChatID, 0)
 	props, _ := h.props.GetEveryHavingProperty("covid19Time")
 	for _, prop := range props {
 		if (prop.User != 0) && (tgbotbase.ChatID(prop.User) != prop.Chat) {
 			log.Printf("COVID-19: Skipping special setting for user %d in chat %d", prop.User, prop.Chat)
 			continue
 		}
-		chatsToNotify = append(chatsToNotify, prop.Chat)
+		h.PropertyChanged(prop.Chat, prop.Value)
 	}
 
 	countriesOfInterestL10N := map[string]string{
@@ -93,9 +103,13 @@ func (h *covid19Handler) Run() {
 	}
 
 	go func() {
+		defer close(h.done)
 		data := covidData{}
 		for {
 			select {
+			case <-h.stopCh:
+				log.Info("COVID-19 updates have been stopped")
+				return
 			case data = <-h.updates:
 				lastCases := data.countryLatest["Russia"].totalCases
 				log.WithFields(log.Fields{"prev": prevLastCases, "new": lastCases}).Debug("New update received")
@@ -123,7 +137,7 @@ func (h *covid19Handler) Run() {
 						text = fmt.Sprintf("%s\n%s", text, n.toMarkdown())
 					}
 				}
-				for _, chatID := range chatsToNotify {
+				for _, chatID := range h.chatsToNotify() {
 					msg := tgbotapi.NewMessage(int64(chatID), text)
 					msg.ParseMode = "MarkdownV2"
 					msg.DisableWebPagePreview = true
@@ -136,6 +150,35 @@ func (h *covid19Handler) Run() {
 	h.cron.AddJob(time.Now(), &covidUpdateJob{updates: h.updates})
 }
 
+// Drain stops the update loop, the returned channel is closed after the update being sent (if any) has been sent
+func (h *covid19Handler) Drain() <-chan struct{} {
+	h.stopOnce.Do(func() {
+		close(h.stopCh)
+	})
+	return h.done
+}
+
+// PropertyChanged adds the chat to or removes it from updates after 'covid19Time' has been changed
+func (h *covid19Handler) PropertyChanged(chat tgbotbase.ChatID, value string) {
+	h.chatsMutex.Lock()
+	defer h.chatsMutex.Unlock()
+	if value == "" {
+		delete(h.chats, chat)
+		return
+	}
+	h.chats[chat] = true
+}
+
+func (h *covid19Handler) chatsToNotify() []tgbotbase.ChatID {
+	h.chatsMutex.Lock()
+	defer h.chatsMutex.Unlock()
+	chats := make([]tgbotbase.ChatID, 0, len(h.chats))
+	for chat := range h.chats {
+		chats = append(chats, chat)
+	}
+	return chats
+}
+
 func (h *covid19Handler) Name() string {
 	return "coronavirus stats at morning"
 }
@@ -145,7 +188,7 @@ type covidJob struct {
 	ch     chan<- tgbotbase.ChatID
 }
 
-var _ tgbotbase.CronJob = &weatherJob{}
+var _ tgbotbase.CronJob = &covidJob{}
 
 const (
 	colDate        = 0
@@ -365,3 +408,9 @@ func getRussiaData() (map[string]casesData, error) {
 	rusCases[nnID] = nnCases
 	return rusCases, err
 }
+
+// Covid19Properties declares properties used by COVID-19 updates
+func Covid19Properties() []PropertyDef {
+	return []PropertyDef{
+		enumProperty("covid19Time", "", "включает обновления по COVID-19 для чата (выключить: /propdelchat covid19Time)", "on")}
+}
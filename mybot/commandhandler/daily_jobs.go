@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+)
+
+// dailyScheduler keeps the only daily job per chat for a feature whose time is set by a chat-level property,
+// e.g. 'catTime' for morning kitties
+type dailyScheduler struct {
+	feature  string
+	property string
+	props    tgbotbase.PropertyStorage
+	cron     tgbotbase.Cron
+	jobs     JobRegistry
+	grace    time.Duration
+	deliver  func(chat tgbotbase.ChatID) bool // returns true if the chat has got what it has been waiting for
+
+	activeMutex sync.Mutex
+	active      map[tgbotbase.ChatID]*dailyJob // the only job which is allowed to deliver to a chat
+}
+
+func newDailyScheduler(feature string,
+	property string,
+	props tgbotbase.PropertyStorage,
+	cron tgbotbase.Cron,
+	jobs JobRegistry,
+	grace time.Duration,
+	deliver func(chat tgbotbase.ChatID) bool) *dailyScheduler {
+	return &dailyScheduler{
+		feature:  feature,
+		property: property,
+		props:    props,
+		cron:     cron,
+		jobs:     jobs,
+		grace:    grace,
+		deliver:  deliver,
+		active:   make(map[tgbotbase.ChatID]*dailyJob)}
+}
+
+// scheduleAll schedules every chat having the property; runs missed not more than grace ago are delivered immediately
+func (s *dailyScheduler) scheduleAll() {
+	now := time.Now()
+	props, err := s.props.GetEveryHavingProperty(s.property)
+	if err != nil {
+		log.WithFields(log.Fields{"feature": s.feature, "property": s.property}).WithError(err).Error("Could not load chats to schedule")
+		return
+	}
+	for _, prop := range props {
+		fields := log.Fields{"feature": s.feature, "user": prop.User, "chat": prop.Chat}
+		if (prop.User != 0) && (tgbotbase.ChatID(prop.User) != prop.Chat) {
+			log.WithFields(fields).Info("Skipping special setting of a user")
+			continue
+		}
+		if prop.Value == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(prop.Value)
+		if err != nil {
+			log.WithFields(fields).WithError(err).Errorf("Could not parse duration %s", prop.Value)
+			continue
+		}
+		s.schedule(prop.Chat, calcFirstDailyRun(now, dur, s.grace, s.jobs.LastRun(s.feature, prop.Chat)))
+	}
+}
+
+// PropertyChanged reschedules the chat after its property has been changed
+func (s *dailyScheduler) PropertyChanged(chat tgbotbase.ChatID, value string) {
+	if value == "" {
+		s.unschedule(chat)
+		return
+	}
+	dur, err := time.ParseDuration(value)
+	if err != nil {
+		log.WithFields(log.Fields{"feature": s.feature, "chat": chat}).WithError(err).Errorf("Could not parse duration %s", value)
+		return
+	}
+	s.schedule(chat, tgbotbase.CalcNextTimeFromMidnight(time.Now(), dur))
+}
+
+// schedule replaces the job of the chat with a new one starting at 'when'
+func (s *dailyScheduler) schedule(chat tgbotbase.ChatID, when time.Time) {
+	job := &dailyJob{scheduler: s, chat: chat}
+
+	s.activeMutex.Lock()
+	if prev, found := s.active[chat]; found {
+		prev.cancel()
+	}
+	s.active[chat] = job
+	s.activeMutex.Unlock()
+
+	log.WithFields(log.Fields{"feature": s.feature, "chat": chat, "when": when}).Info("Daily job is scheduled")
+	s.cron.AddJob(when, job)
+}
+
+// unschedule stops delivery to the chat
+func (s *dailyScheduler) unschedule(chat tgbotbase.ChatID) {
+	s.activeMutex.Lock()
+	defer s.activeMutex.Unlock()
+	if prev, found := s.active[chat]; found {
+		prev.cancel()
+		delete(s.active, chat)
+	}
+}
+
+type dailyJob struct {
+	scheduler *dailyScheduler
+	chat      tgbotbase.ChatID
+	cancelled int32
+}
+
+var _ tgbotbase.CancellableCronJob = &dailyJob{}
+
+func (job *dailyJob) cancel() {
+	atomic.StoreInt32(&job.cancelled, 1)
+}
+
+// Cancelled lets the cron skip the job in its status
+func (job *dailyJob) Cancelled() bool {
+	return atomic.LoadInt32(&job.cancelled) != 0
+}
+
+func (job *dailyJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
+	s := job.scheduler
+	if job.Cancelled() {
+		log.WithFields(log.Fields{"feature": s.feature, "chat": job.chat}).Info("Daily job has been cancelled")
+		return
+	}
+	defer cron.AddJob(scheduledWhen.Add(24*time.Hour), job)
+
+	if s.deliver(job.chat) {
+		s.jobs.MarkRun(s.feature, job.chat, time.Now())
+	}
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import "testing"
+import "time"
+import "github.com/admirallarimda/tgbotbase"
+
+// testCron only remembers added jobs, they are executed by the test
+type testCron struct {
+	jobs []tgbotbase.CronJob
+}
+
+func (c *testCron) AddJob(when time.Time, job tgbotbase.CronJob) {
+	c.jobs = append(c.jobs, job)
+}
+
+type testJobRegistry map[tgbotbase.ChatID]time.Time
+
+func (r testJobRegistry) LastRun(feature string, chat tgbotbase.ChatID) time.Time {
+	return r[chat]
+}
+
+func (r testJobRegistry) MarkRun(feature string, chat tgbotbase.ChatID, t time.Time) {
+	r[chat] = t
+}
+
+func TestDailySchedulerReschedule(t *testing.T) {
+	cron := &testCron{}
+	registry := testJobRegistry{}
+	delivered := 0
+	s := newDailyScheduler("test", "testTime", nil, cron, registry, 0, func(chat tgbotbase.ChatID) bool {
+		delivered++
+		return true
+	})
+
+	s.PropertyChanged(1, "8h")
+	s.PropertyChanged(1, "9h")
+	if len(cron.jobs) != 2 {
+		t.Fatal(len(cron.jobs))
+	}
+	first, second := cron.jobs[0], cron.jobs[1]
+
+	first.Do(time.Now(), cron)
+	if delivered != 0 || len(cron.jobs) != 2 {
+		t.Fatal("replaced job has been executed", delivered, len(cron.jobs))
+	}
+	second.Do(time.Now(), cron)
+	if delivered != 1 || registry[1].IsZero() {
+		t.Fatal("active job has not been executed", delivered, registry)
+	}
+	if len(cron.jobs) != 3 || cron.jobs[2] != second {
+		t.Fatal("active job has not been scheduled for the next day")
+	}
+
+	s.PropertyChanged(1, "")
+	if !second.(tgbotbase.CancellableCronJob).Cancelled() {
+		t.Fatal("job has not been cancelled after the property has been deleted")
+	}
+	s.PropertyChanged(1, "not a time")
+	if len(cron.jobs) != 3 {
+		t.Fatal("invalid time has been scheduled")
+	}
+}
+
+func TestDailySchedulerFailedDelivery(t *testing.T) {
+	cron := &testCron{}
+	registry := testJobRegistry{}
+	s := newDailyScheduler("test", "testTime", nil, cron, registry, 0, func(chat tgbotbase.ChatID) bool {
+		return false
+	})
+	s.schedule(1, time.Now())
+	cron.jobs[0].Do(time.Now(), cron)
+	if !registry[1].IsZero() {
+		t.Fatal("failed delivery has been marked as run")
+	}
+	if len(cron.jobs) != 2 {
+		t.Fatal("job has not been scheduled for the next day after a failure")
+	}
+}
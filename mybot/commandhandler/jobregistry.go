@@ -0,0 +1,22 @@
+package cmd
+
+import "time"
+import "github.com/admirallarimda/tgbotbase"
+
+// JobRegistry keeps the time of the last successful run of a scheduled job per feature and chat
+type JobRegistry interface {
+	LastRun(feature string, chat tgbotbase.ChatID) time.Time // zero time if the job has never run
+	MarkRun(feature string, chat tgbotbase.ChatID, t time.Time)
+}
+
+// calcFirstDailyRun returns the time when a daily job should be scheduled after startup.
+// If today's run has been missed not more than grace ago and the job has not run since (or has never run), the missed time is returned,
+// so cron executes the job immediately and then proceeds with the usual daily schedule
+func calcFirstDailyRun(now time.Time, fromMidnight time.Duration, grace time.Duration, lastRun time.Time) time.Time {
+	next := tgbotbase.CalcNextTimeFromMidnight(now, fromMidnight)
+	missed := next.Add(-24 * time.Hour)
+	if grace <= 0 || !lastRun.Before(missed) || now.Sub(missed) > grace {
+		return next
+	}
+	return missed
+}
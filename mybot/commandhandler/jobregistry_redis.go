@@ -0,0 +1,40 @@
+package cmd
+
+import "fmt"
+import "time"
+
+import log "github.com/sirupsen/logrus"
+import "github.com/go-redis/redis"
+import "github.com/admirallarimda/tgbotbase"
+
+type RedisJobRegistry struct {
+	client *redis.Client
+}
+
+func NewRedisJobRegistry(pool tgbotbase.RedisPool) JobRegistry {
+	r := RedisJobRegistry{client: pool.GetConnByName("reminder")}
+	return &r
+}
+
+func jobRunKey(feature string, chat tgbotbase.ChatID) string {
+	return fmt.Sprintf("jobrun:%s:%d", feature, chat)
+}
+
+func (r *RedisJobRegistry) LastRun(feature string, chat tgbotbase.ChatID) time.Time {
+	key := jobRunKey(feature, chat)
+	ts, err := r.client.Get(key).Int64()
+	if err != nil {
+		if err != redis.Nil {
+			log.Printf("redisJobRegistry: could not get last run by key '%s' due to error: %s", key, err)
+		}
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
+
+func (r *RedisJobRegistry) MarkRun(feature string, chat tgbotbase.ChatID, t time.Time) {
+	key := jobRunKey(feature, chat)
+	if err := r.client.Set(key, t.Unix(), 0).Err(); err != nil {
+		log.Printf("redisJobRegistry: could not store last run by key '%s' due to error: %s", key, err)
+	}
+}
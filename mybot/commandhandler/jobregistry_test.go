@@ -0,0 +1,33 @@
+package cmd
+
+import "testing"
+import "time"
+
+func TestCalcFirstDailyRun(t *testing.T) {
+	day := time.Date(2020, 3, 10, 0, 0, 0, 0, time.Local)
+	at := func(h, m int) time.Time {
+		return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
+	}
+	tomorrow := at(8, 0).Add(24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		grace   time.Duration
+		lastRun time.Time
+		want    time.Time
+	}{
+		{"before today's run", at(7, 0), 2 * time.Hour, at(8, 0).Add(-24 * time.Hour), at(8, 0)},
+		{"missed within grace", at(8, 5), 2 * time.Hour, at(8, 0).Add(-24 * time.Hour), at(8, 0)},
+		{"missed within grace, never run", at(8, 5), 2 * time.Hour, time.Time{}, at(8, 0)},
+		{"missed beyond grace", at(10, 30), 2 * time.Hour, at(8, 0).Add(-24 * time.Hour), tomorrow},
+		{"already run today", at(8, 5), 2 * time.Hour, at(8, 0), tomorrow},
+		{"no grace", at(8, 5), 0, at(8, 0).Add(-24 * time.Hour), tomorrow},
+	}
+	for _, test := range tests {
+		got := calcFirstDailyRun(test.now, 8*time.Hour, test.grace, test.lastRun)
+		if !got.Equal(test.want) {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
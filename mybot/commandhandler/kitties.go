@@ -1,26 +1,26 @@
 package cmd
 
-import "log"
 import "time"
 import "strings"
 import "os"
 import "io"
 import "path"
 import "net/http"
+import log "github.com/sirupsen/logrus"
 import "gopkg.in/telegram-bot-api.v4"
 import "github.com/admirallarimda/tgbotbase"
 
 type kittiesHandler struct {
 	tgbotbase.BaseHandler
-	properties tgbotbase.PropertyStorage
-	cron       tgbotbase.Cron
+	daily *dailyScheduler
 }
 
-func NewKittiesHandler(cron tgbotbase.Cron, properties tgbotbase.PropertyStorage) tgbotbase.BackgroundMessageHandler {
-	handler := kittiesHandler{
-		properties: properties,
-		cron:       cron}
-	return &handler
+const kittiesFeature = "kitties"
+
+func NewKittiesHandler(cron tgbotbase.Cron, properties tgbotbase.PropertyStorage, jobs JobRegistry, grace time.Duration) *kittiesHandler {
+	handler := &kittiesHandler{}
+	handler.daily = newDailyScheduler(kittiesFeature, "catTime", properties, cron, jobs, grace, handler.sendKitty)
+	return handler
 }
 
 func (h *kittiesHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) {
@@ -32,39 +32,22 @@ func (h *kittiesHandler) Name() string {
 }
 
 func (h *kittiesHandler) Run() {
-	now := time.Now()
-	props, _ := h.properties.GetEveryHavingProperty("catTime")
-	for _, prop := range props {
-		if (prop.User != 0) && (tgbotbase.ChatID(prop.User) != prop.Chat) {
-			log.Printf("Morning kitties: Skipping special setting for user %d in chat %d", prop.User, prop.Chat)
-			continue
-		}
-		dur, err := time.ParseDuration(prop.Value)
-		if err != nil {
-			log.Printf("Could not parse duration %s for chat %d due to error: %s", prop.Value, prop.Chat, err)
-			continue
-		}
-		when := tgbotbase.CalcNextTimeFromMidnight(now, dur)
-		job := kittiesJob{chatID: prop.Chat}
-		job.OutMsgCh = h.OutMsgCh
-		h.cron.AddJob(when, &job)
-	}
+	h.daily.scheduleAll()
 }
 
-type kittiesJob struct {
-	tgbotbase.BaseHandler
-	chatID tgbotbase.ChatID
+// PropertyChanged reschedules kitties of the chat after 'catTime' has been changed
+func (h *kittiesHandler) PropertyChanged(chat tgbotbase.ChatID, value string) {
+	h.daily.PropertyChanged(chat, value)
 }
 
-func (job *kittiesJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
-	defer cron.AddJob(scheduledWhen.Add(24*time.Hour), job)
+func (h *kittiesHandler) sendKitty(chat tgbotbase.ChatID) bool {
 	const url = "http://thecatapi.com/api/images/get?format=src&type=jpg"
 
 	log.Printf("Preparing to load new catpic using %s", url)
 	resp, err := http.Get(url)
 	if err != nil {
 		log.Printf("Error has been occured during loading cat: %s. Aborting loading", err)
-		return
+		return false
 	}
 	defer resp.Body.Close()
 
@@ -77,19 +60,26 @@ func (job *kittiesJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
 	file, err := os.Create(fpath)
 	if err != nil {
 		log.Printf("Could not create new file for a cat %s due to error: %s. Skipping this one", filename, err)
-		return
+		return false
 	}
 	// Use io.Copy to just dump the response body to the file. This supports huge files
 	_, err = io.Copy(file, resp.Body)
 	if err != nil {
 		// TODO: remove created file
 		log.Printf("Could not store a catpic from the Internet to %s due to error: %s", filename, err)
-		return
+		return false
 	}
 	file.Close()
 
-	picMsg := tgbotapi.NewPhotoUpload(int64(job.chatID), fpath)
+	picMsg := tgbotapi.NewPhotoUpload(int64(chat), fpath)
 	picMsg.Caption = "утренний котик!"
 
-	job.OutMsgCh <- picMsg
+	h.OutMsgCh <- picMsg
+	return true
+}
+
+// KittiesProperties declares properties used by morning kitties
+func KittiesProperties() []PropertyDef {
+	return []PropertyDef{
+		dailyTimeProperty("catTime", "время утренней картинки с котиком (задавать для чата)")}
 }
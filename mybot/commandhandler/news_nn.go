@@ -12,15 +12,15 @@ import (
 
 type newsNNHandler struct {
 	tgbotbase.BaseHandler
-	properties tgbotbase.PropertyStorage
-	cron       tgbotbase.Cron
+	daily *dailyScheduler
 }
 
-func NewNewsNNHandler(cron tgbotbase.Cron, properties tgbotbase.PropertyStorage) tgbotbase.BackgroundMessageHandler {
-	handler := newsNNHandler{
-		properties: properties,
-		cron:       cron}
-	return &handler
+const newsNNFeature = "nnNews"
+
+func NewNewsNNHandler(cron tgbotbase.Cron, properties tgbotbase.PropertyStorage, jobs JobRegistry, grace time.Duration) *newsNNHandler {
+	handler := &newsNNHandler{}
+	handler.daily = newDailyScheduler(newsNNFeature, "nnNewsTime", properties, cron, jobs, grace, handler.sendNews)
+	return handler
 }
 
 func (h *newsNNHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) {
@@ -32,42 +32,24 @@ func (h *newsNNHandler) Name() string {
 }
 
 func (h *newsNNHandler) Run() {
-	now := time.Now()
-	props, _ := h.properties.GetEveryHavingProperty("nnNewsTime")
-	for _, prop := range props {
-		if (prop.User != 0) && (tgbotbase.ChatID(prop.User) != prop.Chat) {
-			log.Printf("NN News: Skipping special setting for user %d in chat %d", prop.User, prop.Chat)
-			continue
-		}
-		dur, err := time.ParseDuration(prop.Value)
-		if err != nil {
-			log.Printf("Could not parse duration %s for chat %d due to error: %s", prop.Value, prop.Chat, err)
-			continue
-		}
-		when := tgbotbase.CalcNextTimeFromMidnight(now, dur)
-		job := newsNNJob{chatID: prop.Chat}
-		job.OutMsgCh = h.OutMsgCh
-		h.cron.AddJob(when, &job)
-	}
+	h.daily.scheduleAll()
 }
 
-type newsNNJob struct {
-	tgbotbase.BaseHandler
-	chatID tgbotbase.ChatID
+// PropertyChanged reschedules news of the chat after 'nnNewsTime' has been changed
+func (h *newsNNHandler) PropertyChanged(chat tgbotbase.ChatID, value string) {
+	h.daily.PropertyChanged(chat, value)
 }
 
-func (job *newsNNJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
-	defer cron.AddJob(scheduledWhen.Add(24*time.Hour), job)
-
+func (h *newsNNHandler) sendNews(chat tgbotbase.ChatID) bool {
 	news, err := loadYaNews(YaNewsNN)
 	if err != nil {
 		log.WithFields(log.Fields{"error": err}).Error("error loading NN news")
-		return
+		return false
 	}
 
 	if len(news) == 0 {
 		log.Error("no news loaded")
-		return
+		return false
 	}
 
 	text := "Нижегородские вести:"
@@ -75,8 +57,15 @@ func (job *newsNNJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
 		text = fmt.Sprintf("%s\n%s", text, n.toMarkdown())
 	}
 
-	msg := tgbotapi.NewMessage(int64(job.chatID), text)
+	msg := tgbotapi.NewMessage(int64(chat), text)
 	msg.ParseMode = "MarkdownV2"
 	msg.DisableWebPagePreview = true
-	job.OutMsgCh <- msg
+	h.OutMsgCh <- msg
+	return true
+}
+
+// NewsNNProperties declares properties used by NN news
+func NewsNNProperties() []PropertyDef {
+	return []PropertyDef{
+		dailyTimeProperty("nnNewsTime", "время ежедневных новостей Нижнего Новгорода (задавать для чата)")}
 }
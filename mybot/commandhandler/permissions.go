@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const chatSettingsDenied = "Менять настройки всего чата могут только администраторы чата и владельцы бота"
+
+// Permissions decides who may change settings which affect the whole chat
+type Permissions struct {
+	api *tgbotapi.BotAPI
+
+	ownersMutex sync.RWMutex
+	owners      []string
+}
+
+// NewPermissions creates permission checks; owners are usernames (with or without '@') or numeric user IDs from config
+func NewPermissions(api *tgbotapi.BotAPI, owners []string) *Permissions {
+	p := &Permissions{api: api}
+	p.SetOwners(owners)
+	return p
+}
+
+// SetOwners replaces the list of owners, e.g. after config reload
+func (p *Permissions) SetOwners(owners []string) {
+	normalized := make([]string, 0, len(owners))
+	for _, owner := range owners {
+		owner = strings.TrimPrefix(strings.TrimSpace(owner), "@")
+		if owner != "" {
+			normalized = append(normalized, strings.ToLower(owner))
+		}
+	}
+	p.ownersMutex.Lock()
+	p.owners = normalized
+	p.ownersMutex.Unlock()
+}
+
+func (p *Permissions) IsOwner(user *tgbotapi.User) bool {
+	if user == nil {
+		return false
+	}
+	p.ownersMutex.RLock()
+	defer p.ownersMutex.RUnlock()
+	return isOwner(p.owners, user)
+}
+
+// isOwner checks the user against owners given as usernames (with or without '@') or numeric IDs
+func isOwner(owners []string, user *tgbotapi.User) bool {
+	if user == nil {
+		return false
+	}
+	id := strconv.Itoa(user.ID)
+	for _, owner := range owners {
+		owner = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(owner), "@"))
+		if owner != "" && (owner == id || (user.UserName != "" && owner == strings.ToLower(user.UserName))) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanChangeChat checks whether the user may change chat-level settings: owners always can, in private chats the user is the chat, in groups Telegram admins are asked
+func (p *Permissions) CanChangeChat(user *tgbotapi.User, chat *tgbotapi.Chat) bool {
+	if user == nil || chat == nil {
+		return false
+	}
+	if p.IsOwner(user) {
+		return true
+	}
+	if chat.IsPrivate() {
+		return true
+	}
+	if p.api == nil {
+		log.WithFields(log.Fields{"user": user.ID, "chat": chat.ID}).Warn("No Telegram API to check chat admins, denying")
+		return false
+	}
+
+	member, err := p.api.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: user.ID})
+	if err != nil {
+		log.WithFields(log.Fields{"user": user.ID, "chat": chat.ID}).WithError(err).Error("Could not get chat member")
+		return false
+	}
+	allowed := member.IsCreator() || member.IsAdministrator()
+	log.WithFields(log.Fields{"user": user.ID, "chat": chat.ID, "status": member.Status, "allowed": allowed}).Debug("Chat settings permission checked")
+	return allowed
+}
+
+// canChangeChatIn is a shortcut for handlers which receive chat-level changes as messages
+func (p *Permissions) canChangeChatIn(msg tgbotapi.Message) bool {
+	return p.CanChangeChat(msg.From, msg.Chat)
+}
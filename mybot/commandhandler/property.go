@@ -1,45 +1,288 @@
 package cmd
 
-import "log"
+import "fmt"
+import "sort"
+import "strconv"
 import "strings"
+import "time"
+import log "github.com/sirupsen/logrus"
 import "gopkg.in/telegram-bot-api.v4"
 import "github.com/admirallarimda/tgbotbase"
 
+const propertyUsage = `Использование:
+/propset <имя> <значение> - задать свойство для себя в этом чате
+/propsetchat <имя> <значение> - задать свойство для всего чата
+/propget <имя> - показать действующее значение свойства
+/proplist - показать все свойства, заданные для вас и этого чата
+/propdel <имя> - удалить ваше свойство в этом чате
+/propdelchat <имя> - удалить свойство всего чата
+/prophelp [имя] - список всех известных свойств
+/settingslog [N] - последние изменения настроек чата`
+
 type propertyHandler struct {
-	storage tgbotbase.PropertyStorage
+	tgbotbase.BaseHandler
+	storage     tgbotbase.PropertyStorage
+	registry    *PropertyRegistry
+	permissions *Permissions
+	audit       SettingsLog
 }
 
 var _ tgbotbase.IncomingMessageHandler = &propertyHandler{}
 
-func NewPropertyHandler(storage tgbotbase.PropertyStorage) *propertyHandler {
-	return &propertyHandler{storage: storage}
+func NewPropertyHandler(storage tgbotbase.PropertyStorage, registry *PropertyRegistry, permissions *Permissions, audit SettingsLog) *propertyHandler {
+	return &propertyHandler{storage: storage, registry: registry, permissions: permissions, audit: audit}
 }
 
 func (h *propertyHandler) HandleOne(msg tgbotapi.Message) {
-	args := msg.CommandArguments()
 	user := tgbotbase.UserID(msg.From.ID)
 	chat := tgbotbase.ChatID(msg.Chat.ID)
 
-	splits := strings.SplitN(args, " ", 2)
-	if len(splits) != 2 {
+	var reply string
+	switch msg.Command() {
+	case "propsetchat", "propdelchat":
+		if !h.permissions.canChangeChatIn(msg) {
+			log.Printf("User %d is not allowed to change chat-level properties in chat %d", user, chat)
+			reply = chatSettingsDenied
+			break
+		}
+		if msg.Command() == "propsetchat" {
+			reply = h.set(msg, user, chat)
+		} else {
+			reply = h.delete(msg, user, chat)
+		}
+	case "propset":
+		reply = h.set(msg, user, chat)
+	case "propget":
+		reply = h.get(strings.TrimSpace(msg.CommandArguments()), user, chat)
+	case "proplist":
+		reply = h.list(user, chat)
+	case "propdel":
+		reply = h.delete(msg, user, chat)
+	case "prophelp":
+		reply = h.help(strings.TrimSpace(msg.CommandArguments()))
+	case "settingslog":
+		reply = h.changes(strings.TrimSpace(msg.CommandArguments()), user, chat)
+	default:
+		reply = propertyUsage
+	}
+
+	h.OutMsgCh <- tgbotapi.NewMessage(msg.Chat.ID, reply)
+}
+
+func (h *propertyHandler) set(msg tgbotapi.Message, user tgbotbase.UserID, chat tgbotbase.ChatID) string {
+	command, args := msg.Command(), msg.CommandArguments()
+	splits := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if len(splits) != 2 || strings.TrimSpace(splits[1]) == "" {
 		log.Printf("Could not split property arguments '%s' into name + value", args)
-		return
+		return fmt.Sprintf("Нужно указать имя и значение: /%s <имя> <значение>", command)
 	}
 	propname := splits[0]
-	propvalue := splits[1]
+	propvalue, err := h.registry.Validate(propname, strings.TrimSpace(splits[1]))
+	if err != nil {
+		log.Printf("Property '%s' value '%s' is rejected: %s", propname, splits[1], err)
+		return fmt.Sprintf("Свойство не изменено: %s. Список свойств: /prophelp", err)
+	}
 
-	if msg.Command() == "propsetchat" {
+	if command == "propsetchat" {
 		user = 0
 	}
 
-	err := h.storage.SetPropertyForUserInChat(propname, user, chat, propvalue)
+	old := exactPropertyValue(h.storage, propname, user, chat)
+	err = h.storage.SetPropertyForUserInChat(propname, user, chat, propvalue)
 	if err != nil {
 		log.Printf("Could not correctly set property '%s' for user %d chat %d due to error: %s", propname, user, chat, err)
+		return fmt.Sprintf("Не удалось сохранить свойство '%s'", propname)
+	}
+	h.registry.Changed(propname, user, chat, propvalue)
+	h.audit.Record(chat, newSettingsChange(msg.From, propname, user, old, propvalue, command))
+	return fmt.Sprintf("Свойство '%s' = '%s' (%s)", propname, propvalue, propertyLevel(tgbotbase.PropertyValue{User: user, Chat: chat}))
+}
+
+func (h *propertyHandler) get(name string, user tgbotbase.UserID, chat tgbotbase.ChatID) string {
+	if name == "" || strings.Contains(name, " ") {
+		return "Нужно указать имя свойства: /propget <имя>"
+	}
+	props, err := h.storage.GetPropertiesForUserInChat(user, chat)
+	if err != nil {
+		log.Printf("Could not load properties for user %d chat %d due to error: %s", user, chat, err)
+		return "Не удалось загрузить свойства"
+	}
+
+	values := make([]tgbotbase.PropertyValue, 0)
+	for _, p := range props {
+		if p.Name == name && p.Value != "" {
+			values = append(values, p)
+		}
+	}
+	if len(values) == 0 {
+		if def, found := h.registry.Lookup(name); found && def.Default != "" {
+			return fmt.Sprintf("Свойство '%s' не задано, по умолчанию: %s", name, def.Default)
+		}
+		return fmt.Sprintf("Свойство '%s' не задано", name)
+	}
+	sortByPriority(values, user, chat)
+
+	lines := []string{fmt.Sprintf("%s = %s (%s)", name, values[0].Value, propertyLevel(values[0]))}
+	for _, v := range values[1:] {
+		lines = append(lines, fmt.Sprintf("  перекрыто: %s (%s)", v.Value, propertyLevel(v)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h *propertyHandler) list(user tgbotbase.UserID, chat tgbotbase.ChatID) string {
+	props, err := h.storage.GetPropertiesForUserInChat(user, chat)
+	if err != nil {
+		log.Printf("Could not load properties for user %d chat %d due to error: %s", user, chat, err)
+		return "Не удалось загрузить свойства"
+	}
+
+	byName := make(map[string][]tgbotbase.PropertyValue)
+	for _, p := range props {
+		if p.Value == "" {
+			continue
+		}
+		byName[p.Name] = append(byName[p.Name], p)
+	}
+	if len(byName) == 0 {
+		return "Свойства не заданы"
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		values := byName[name]
+		sortByPriority(values, user, chat)
+		lines = append(lines, fmt.Sprintf("%s = %s (%s)", name, values[0].Value, propertyLevel(values[0])))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h *propertyHandler) delete(msg tgbotapi.Message, user tgbotbase.UserID, chat tgbotbase.ChatID) string {
+	command, name := msg.Command(), strings.TrimSpace(msg.CommandArguments())
+	if name == "" || strings.Contains(name, " ") {
+		return fmt.Sprintf("Нужно указать имя свойства: /%s <имя>", command)
+	}
+	if command == "propdelchat" {
+		user = 0
+	}
+
+	props, err := h.storage.GetPropertiesForUserInChat(user, chat)
+	if err != nil {
+		log.Printf("Could not load properties for user %d chat %d due to error: %s", user, chat, err)
+		return "Не удалось загрузить свойства"
+	}
+	found := false
+	old := ""
+	for _, p := range props {
+		if p.Name == name && p.User == user && p.Chat == chat {
+			found = true
+			old = p.Value
+			break
+		}
+	}
+	level := propertyLevel(tgbotbase.PropertyValue{User: user, Chat: chat})
+	if !found {
+		return fmt.Sprintf("Свойство '%s' не задано (%s)", name, level)
+	}
+
+	if err := h.storage.DeletePropertyForUserInChat(name, user, chat); err != nil {
+		log.Printf("Could not delete property '%s' for user %d chat %d due to error: %s", name, user, chat, err)
+		return fmt.Sprintf("Не удалось удалить свойство '%s'", name)
+	}
+	h.registry.Changed(name, user, chat, "")
+	h.audit.Record(chat, newSettingsChange(msg.From, name, user, old, "", command))
+	return fmt.Sprintf("Свойство '%s' удалено (%s)", name, level)
+}
+
+func (h *propertyHandler) help(name string) string {
+	defs := h.registry.All()
+	if name != "" {
+		def, found := h.registry.Lookup(name)
+		if !found {
+			return fmt.Sprintf("Неизвестное свойство '%s'", name)
+		}
+		defs = []PropertyDef{def}
+	}
+
+	lines := make([]string, 0, len(defs))
+	for _, def := range defs {
+		line := fmt.Sprintf("%s - %s; %s", def.Name, def.Description, def.Type)
+		if def.Default != "" {
+			line += fmt.Sprintf("; по умолчанию: %s", def.Default)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+const (
+	settingsLogDefault = 10
+	settingsLogMax     = 50
+)
+
+func (h *propertyHandler) changes(args string, user tgbotbase.UserID, chat tgbotbase.ChatID) string {
+	n := settingsLogDefault
+	if args != "" {
+		parsed, err := strconv.Atoi(args)
+		if err != nil || parsed <= 0 {
+			return "Использование: /settingslog [количество записей]"
+		}
+		n = parsed
+	}
+	if n > settingsLogMax {
+		n = settingsLogMax
+	}
+
+	changes := h.audit.Recent(chat, n)
+	if len(changes) == 0 {
+		return "Настройки этого чата ещё не менялись"
+	}
+	loc := inUserTimezone(h.storage, user, chat, time.Now()).Location()
+	lines := make([]string, 0, len(changes)+1)
+	lines = append(lines, "Последние изменения настроек:")
+	for _, c := range changes {
+		lines = append(lines, formatSettingsChange(c, loc))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// propertyPriority mirrors lookup order of PropertyStorage.GetProperty: user in chat, then user-wide, then chat-wide
+func propertyPriority(p tgbotbase.PropertyValue, user tgbotbase.UserID, chat tgbotbase.ChatID) int {
+	switch {
+	case p.User == user && p.Chat == chat:
+		return 0
+	case p.User == user && p.Chat == tgbotbase.ChatID(user):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func sortByPriority(values []tgbotbase.PropertyValue, user tgbotbase.UserID, chat tgbotbase.ChatID) {
+	sort.SliceStable(values, func(i, j int) bool {
+		return propertyPriority(values[i], user, chat) < propertyPriority(values[j], user, chat)
+	})
+}
+
+func propertyLevel(p tgbotbase.PropertyValue) string {
+	switch {
+	case p.User == 0:
+		return "для чата"
+	case p.Chat == tgbotbase.ChatID(p.User):
+		return "ваше личное"
+	default:
+		return "ваше в этом чате"
 	}
 }
 
 func (h *propertyHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
-	return tgbotbase.NewHandlerTrigger(nil, []string{"propset", "propsetchat"})
+	h.OutMsgCh = outMsgCh
+	return tgbotbase.NewHandlerTrigger(nil, []string{"propset", "propsetchat", "propget", "proplist", "propdel", "propdelchat", "prophelp", "settingslog"})
 }
 
 func (h *propertyHandler) Name() string {
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+)
+
+// PropertyValidator checks a value which is going to be set and returns it in the form it should be stored in
+type PropertyValidator func(value string) (string, error)
+
+// PropertyDef describes a single setting which can be changed via /propset
+type PropertyDef struct {
+	Name        string
+	Type        string // human-readable type shown in /prophelp
+	Default     string // empty if the feature is off when the property is not set
+	Description string
+	Validate    PropertyValidator
+}
+
+// PropertyChangeFunc is called after a chat-level property has been changed; empty value means that the property is removed
+type PropertyChangeFunc func(chat tgbotbase.ChatID, value string)
+
+// PropertyRegistry keeps every known property; it is filled on start and is read-only afterwards
+type PropertyRegistry struct {
+	defs     map[string]PropertyDef
+	watchers map[string][]PropertyChangeFunc
+}
+
+func NewPropertyRegistry() *PropertyRegistry {
+	return &PropertyRegistry{
+		defs:     make(map[string]PropertyDef),
+		watchers: make(map[string][]PropertyChangeFunc)}
+}
+
+// OnChange subscribes to changes of the property so that e.g. daily jobs are rescheduled without restart
+func (r *PropertyRegistry) OnChange(name string, f PropertyChangeFunc) {
+	r.watchers[name] = append(r.watchers[name], f)
+}
+
+// Changed notifies watchers; only chat-level values (set for the chat or by the user in a private chat) are propagated as daily jobs ignore others
+func (r *PropertyRegistry) Changed(name string, user tgbotbase.UserID, chat tgbotbase.ChatID, value string) {
+	if user != 0 && tgbotbase.ChatID(user) != chat {
+		return
+	}
+	for _, f := range r.watchers[name] {
+		f(chat, value)
+	}
+}
+
+// Resync passes current chat-level values of every watched property to watchers, e.g. after the storage has been edited by hand
+func (r *PropertyRegistry) Resync(storage tgbotbase.PropertyStorage) error {
+	for name, watchers := range r.watchers {
+		props, err := storage.GetEveryHavingProperty(name)
+		if err != nil {
+			return err
+		}
+		for _, p := range props {
+			if p.User != 0 && tgbotbase.ChatID(p.User) != p.Chat {
+				continue
+			}
+			for _, f := range watchers {
+				f(p.Chat, p.Value)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *PropertyRegistry) Register(defs ...PropertyDef) {
+	for _, def := range defs {
+		r.defs[def.Name] = def
+	}
+}
+
+func (r *PropertyRegistry) Lookup(name string) (PropertyDef, bool) {
+	def, found := r.defs[name]
+	return def, found
+}
+
+// All returns every registered property sorted by name
+func (r *PropertyRegistry) All() []PropertyDef {
+	defs := make([]PropertyDef, 0, len(r.defs))
+	for _, def := range r.defs {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+	return defs
+}
+
+// Validate checks the value of a known property and returns the normalized value
+func (r *PropertyRegistry) Validate(name, value string) (string, error) {
+	def, found := r.defs[name]
+	if !found {
+		return "", fmt.Errorf("неизвестное свойство '%s'", name)
+	}
+	if def.Validate == nil {
+		return value, nil
+	}
+	return def.Validate(value)
+}
+
+func dailyTimeProperty(name, description string) PropertyDef {
+	return PropertyDef{
+		Name:        name,
+		Type:        "время (07:30 или 7h30m)",
+		Description: description,
+		Validate: func(value string) (string, error) {
+			dur, err := parseDailyTime(value)
+			if err != nil {
+				return "", fmt.Errorf("некорректное время '%s', ожидается 07:30 или 7h30m", value)
+			}
+			return formatDailyTime(dur), nil
+		}}
+}
+
+func enumProperty(name, def, description string, values ...string) PropertyDef {
+	return PropertyDef{
+		Name:        name,
+		Type:        "одно из: " + strings.Join(values, ", "),
+		Default:     def,
+		Description: description,
+		Validate: func(value string) (string, error) {
+			lowered := strings.ToLower(value)
+			for _, v := range values {
+				if lowered == v {
+					return v, nil
+				}
+			}
+			return "", fmt.Errorf("значение '%s' не из списка: %s", value, strings.Join(values, ", "))
+		}}
+}
+
+func timezoneProperty(name, description string) PropertyDef {
+	return PropertyDef{
+		Name:        name,
+		Type:        "часовой пояс (Europe/Moscow)",
+		Default:     serverTimezone(),
+		Description: description,
+		Validate: func(value string) (string, error) {
+			if _, err := time.LoadLocation(value); err != nil {
+				return "", fmt.Errorf("неизвестный часовой пояс '%s'", value)
+			}
+			return value, nil
+		}}
+}
+
+// serverTimezone names the zone times are in when no timezone is set
+func serverTimezone() string {
+	if name := time.Local.String(); name != "Local" {
+		return name
+	}
+	name, offset := time.Now().Zone()
+	if offset < 0 {
+		return fmt.Sprintf("%s (UTC-%02d:%02d)", name, -offset/3600, -offset%3600/60)
+	}
+	return fmt.Sprintf("%s (UTC+%02d:%02d)", name, offset/3600, offset%3600/60)
+}
+
+func cityProperty(name, description string, cities CityIndex) PropertyDef {
+	return PropertyDef{
+		Name:        name,
+		Type:        "город",
+		Description: description,
+		Validate: func(value string) (string, error) {
+			if _, err := getCityIDByName(cities, value); err != nil {
+				if weatherErrorKindOf(err) == weatherErrUnknownCity {
+					return "", fmt.Errorf("неизвестный город '%s'", value)
+				}
+				return "", fmt.Errorf("не удалось проверить город '%s'", value)
+			}
+			return value, nil
+		}}
+}
+
+var reLangCode = regexp.MustCompile("^[a-z]{2}(_[a-z]{2})?$")
+
+func langProperty(name, def, description string) PropertyDef {
+	return PropertyDef{
+		Name:        name,
+		Type:        "код языка (ru, en, ...)",
+		Default:     def,
+		Description: description,
+		Validate: func(value string) (string, error) {
+			lowered := strings.ToLower(value)
+			if !reLangCode.MatchString(lowered) {
+				return "", fmt.Errorf("некорректный код языка '%s'", value)
+			}
+			return lowered, nil
+		}}
+}
@@ -1,7 +1,7 @@
 package cmd
 
+import log "github.com/sirupsen/logrus"
 import "github.com/admirallarimda/tgbotbase"
-import "log"
 import "regexp"
 import "time"
 import "strconv"
@@ -28,8 +28,14 @@ func newRemindCronJob(storage ReminderStorage, outMsgCh chan<- tgbotapi.Chattabl
 }
 
 func (j *remindCronJob) Do(scheduled time.Time, cron tgbotbase.Cron) {
-	msg := tgbotapi.NewMessage(int64(j.reminder.chat), "Напоминаю")
-	msg.BaseChat.ReplyToMessageID = j.reminder.replyTo
+	text := "Напоминаю"
+	if j.reminder.text != "" {
+		text = fmt.Sprintf("Напоминаю: %s", j.reminder.text)
+	}
+	msg := tgbotapi.NewMessage(int64(j.reminder.chat), text)
+	if j.reminder.replyTo != 0 {
+		msg.BaseChat.ReplyToMessageID = j.reminder.replyTo
+	}
 
 	j.outMsgCh <- msg
 	j.storage.RemoveReminder(j.reminder)
@@ -115,16 +121,11 @@ func (h *remindHandler) HandleOne(msg tgbotapi.Message) {
 	job := newRemindCronJob(h.storage, h.OutMsgCh, Reminder{
 		chat:    tgbotbase.ChatID(msg.Chat.ID),
 		replyTo: msg.MessageID,
+		text:    msg.CommandArguments(),
 		t:       t})
 	h.cron.AddJob(t, &job)
 
-	tz, _ := h.properties.GetProperty("timezone", tgbotbase.UserID(msg.From.ID), tgbotbase.ChatID(msg.Chat.ID))
-	loc, err := time.LoadLocation(tz)
-	if err != nil {
-		log.Printf("Could not load timezone %s correctly; location loaded with error: %s", tz, err)
-	} else {
-		t = t.In(loc)
-	}
+	t = inUserTimezone(h.properties, tgbotbase.UserID(msg.From.ID), tgbotbase.ChatID(msg.Chat.ID), t)
 
 	replyText := fmt.Sprintf("Принято, напомню около %s", t.Format(timeFormat_Out_Confirm))
 	replyMsg := tgbotapi.NewMessage(msg.Chat.ID, replyText)
@@ -145,6 +146,47 @@ func (h *remindHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tg
 	return tgbotbase.NewHandlerTrigger(nil, []string{"remind", "todo"})
 }
 
+// remindersOfChat returns stored reminders of the chat
+func (h *remindHandler) remindersOfChat(chat tgbotbase.ChatID) []Reminder {
+	reminders := make([]Reminder, 0)
+	for _, r := range h.storage.LoadAll() {
+		if r.chat == chat {
+			reminders = append(reminders, r)
+		}
+	}
+	return reminders
+}
+
+// addReminder stores and schedules a reminder which has not been created via a message (e.g. an imported one).
+// Returns false if exactly the same reminder is already scheduled, so it would fire twice
+func (h *remindHandler) addReminder(r Reminder) bool {
+	if h.storage.HasReminder(r) {
+		return false
+	}
+	job := newRemindCronJob(h.storage, h.OutMsgCh, r)
+	h.cron.AddJob(r.t, &job)
+	return true
+}
+
+// migrateChat moves reminders to the new ID of the chat; already scheduled jobs for the old ID will fail to deliver harmlessly.
+// Message IDs of the old chat mean nothing in the new one, so moved reminders are sent without a reply
+func (h *remindHandler) migrateChat(from, to tgbotbase.ChatID) int {
+	reminders := h.remindersOfChat(from)
+	for _, r := range reminders {
+		h.storage.RemoveReminder(r)
+		r.chat = to
+		r.replyTo = 0
+		h.addReminder(r)
+	}
+	return len(reminders)
+}
+
 func (h *remindHandler) Name() string {
 	return "reminder"
 }
+
+// RemindProperties declares properties used by reminders
+func RemindProperties() []PropertyDef {
+	return []PropertyDef{
+		timezoneProperty("timezone", "часовой пояс для напоминаний и времени в ответах")}
+}
@@ -6,11 +6,13 @@ import "github.com/admirallarimda/tgbotbase"
 type Reminder struct {
 	t       time.Time
 	chat    tgbotbase.ChatID
-	replyTo int // message ID
+	replyTo int    // message ID, 0 if there is no message to reply to
+	text    string // text of the original request
 }
 
 type ReminderStorage interface {
 	AddReminder(Reminder)
 	RemoveReminder(Reminder)
+	HasReminder(Reminder) bool
 	LoadAll() []Reminder
 }
@@ -5,8 +5,8 @@ import "time"
 import "strings"
 import "strconv"
 import "errors"
-import "log"
 
+import log "github.com/sirupsen/logrus"
 import "github.com/go-redis/redis"
 import "github.com/admirallarimda/tgbotbase"
 
@@ -57,13 +57,22 @@ func keyToReminder(key string) (*Reminder, error) {
 }
 
 func (s *RedisReminderStorage) AddReminder(r Reminder) {
-	s.client.Set(reminderKey(r), 0, r.t.Add(24*time.Hour).Sub(time.Now()))
+	s.client.Set(reminderKey(r), r.text, r.t.Add(24*time.Hour).Sub(time.Now()))
 }
 
 func (s *RedisReminderStorage) RemoveReminder(r Reminder) {
 	s.client.Del(reminderKey(r))
 }
 
+func (s *RedisReminderStorage) HasReminder(r Reminder) bool {
+	n, err := s.client.Exists(reminderKey(r)).Result()
+	if err != nil {
+		log.Printf("redisReminder: could not check reminder %+v due to error: %s", r, err)
+		return false
+	}
+	return n > 0
+}
+
 func (s *RedisReminderStorage) LoadAll() []Reminder {
 	keys, err := tgbotbase.GetAllKeys(s.client, "reminder:*")
 	if err != nil {
@@ -77,6 +86,17 @@ func (s *RedisReminderStorage) LoadAll() []Reminder {
 		if err != nil {
 			log.Printf("redisReminder: could not convert reminder key '%s' due to error: %s", k, err)
 		} else {
+			text, err := s.client.Get(k).Result()
+			if err == redis.Nil {
+				continue // expired meanwhile
+			}
+			if err != nil {
+				log.Printf("redisReminder: could not load text of reminder '%s' due to error: %s", k, err)
+			}
+			// reminders stored before the text was kept have "0" as the value
+			if text != "0" {
+				r.text = text
+			}
 			log.Printf("redisReminder: new reminder: %+v", *r)
 			reminders = append(reminders, *r)
 		}
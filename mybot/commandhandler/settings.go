@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const settingsPrefix = "settings:"
+
+// settingsFeature is a daily subscription which can be switched from the menu
+type settingsFeature struct {
+	property string
+	title    string
+	timed    bool // false if the feature has no delivery time and is just switched on
+}
+
+var settingsFeatures = []settingsFeature{
+	{property: "catTime", title: "🐱 Котики", timed: true},
+	{property: "weatherTime", title: "🌤 Погода утром", timed: true},
+	{property: "nnNewsTime", title: "📰 Новости НН", timed: true},
+	{property: "covid19Time", title: "🦠 COVID-19", timed: false}}
+
+var settingsTimePresets = []string{"06:00", "07:00", "07:30", "08:00", "08:30", "09:00", "10:00", "12:00", "18:00"}
+
+var settingsCityPresets = []string{"Москва", "Санкт-Петербург", "Нижний Новгород", "Казань", "Екатеринбург", "Новосибирск"}
+
+var settingsTimezonePresets = []string{"Europe/Kaliningrad", "Europe/Moscow", "Europe/Samara", "Asia/Yekaterinburg", "Asia/Novosibirsk", "Asia/Vladivostok"}
+
+type settingsHandler struct {
+	tgbotbase.BaseHandler
+	storage     tgbotbase.PropertyStorage
+	registry    *PropertyRegistry
+	permissions *Permissions
+	audit       SettingsLog
+}
+
+var _ tgbotbase.IncomingMessageHandler = &settingsHandler{}
+var _ tgbotbase.CallbackQueryHandler = &settingsHandler{}
+
+func NewSettingsHandler(storage tgbotbase.PropertyStorage, registry *PropertyRegistry, permissions *Permissions, audit SettingsLog) *settingsHandler {
+	return &settingsHandler{
+		storage:     storage,
+		registry:    registry,
+		permissions: permissions,
+		audit:       audit}
+}
+
+func (h *settingsHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	return tgbotbase.NewHandlerTrigger(nil, []string{"settings"})
+}
+
+func (h *settingsHandler) Name() string {
+	return "Settings"
+}
+
+func (h *settingsHandler) HandleOne(msg tgbotapi.Message) {
+	text, markup := h.mainMenu(tgbotbase.ChatID(msg.Chat.ID))
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ReplyMarkup = markup
+	h.OutMsgCh <- reply
+}
+
+func (h *settingsHandler) CallbackPrefix() string {
+	return settingsPrefix
+}
+
+// HandleCallback processes 'settings:<action>[:<property>[:<value>]]' button presses and redraws the menu in place
+func (h *settingsHandler) HandleCallback(query tgbotapi.CallbackQuery) *tgbotapi.CallbackConfig {
+	if query.Message == nil || query.Message.Chat == nil {
+		return nil
+	}
+	chat := tgbotbase.ChatID(query.Message.Chat.ID)
+	parts := strings.SplitN(strings.TrimPrefix(query.Data, settingsPrefix), ":", 3)
+	action := parts[0]
+	property := ""
+	if len(parts) > 1 {
+		property = parts[1]
+	}
+	value := ""
+	if len(parts) > 2 {
+		value = parts[2]
+	}
+
+	var answer *tgbotapi.CallbackConfig
+	switch action {
+	case "main":
+	case "close":
+		h.OutMsgCh <- tgbotapi.NewEditMessageText(int64(chat), query.Message.MessageID, "Настройки закрыты, открыть снова: /settings")
+		return nil
+	case "time", "city", "tz":
+		text, markup := h.choiceMenu(action, property)
+		h.redraw(query, text, markup)
+		return nil
+	case "set", "off":
+		if _, found := h.registry.Lookup(property); !found {
+			log.WithFields(log.Fields{"data": query.Data, "chat": chat}).Warn("Unknown property in settings")
+			return nil
+		}
+		if !h.permissions.CanChangeChat(query.From, query.Message.Chat) {
+			alert := tgbotapi.NewCallbackWithAlert(query.ID, chatSettingsDenied)
+			return &alert
+		}
+		answer = h.change(query, chat, action, property, value)
+	default:
+		log.WithFields(log.Fields{"data": query.Data, "chat": chat}).Warn("Unknown settings action")
+		return nil
+	}
+
+	text, markup := h.mainMenu(chat)
+	h.redraw(query, text, markup)
+	return answer
+}
+
+func (h *settingsHandler) change(query tgbotapi.CallbackQuery, chat tgbotbase.ChatID, action, property, value string) *tgbotapi.CallbackConfig {
+	queryID := query.ID
+	fields := log.Fields{"chat": chat, "property": property, "value": value}
+	old := exactPropertyValue(h.storage, property, 0, chat)
+	if action == "off" {
+		if err := h.storage.DeletePropertyForUserInChat(property, 0, chat); err != nil {
+			log.WithFields(fields).WithError(err).Error("Could not delete property from settings")
+			alert := tgbotapi.NewCallbackWithAlert(queryID, "Не удалось сохранить настройку")
+			return &alert
+		}
+		h.registry.Changed(property, 0, chat, "")
+		h.audit.Record(chat, newSettingsChange(query.From, property, 0, old, "", "settings"))
+		answer := tgbotapi.NewCallback(queryID, "Сохранено")
+		return &answer
+	}
+
+	if property == "weatherTime" {
+		if city, _ := h.storage.GetProperty("city", 0, chat); city == "" {
+			alert := tgbotapi.NewCallbackWithAlert(queryID, "Сначала выберите город")
+			return &alert
+		}
+	}
+
+	normalized, err := h.registry.Validate(property, value)
+	if err != nil {
+		log.WithFields(fields).WithError(err).Warn("Settings value is rejected")
+		alert := tgbotapi.NewCallbackWithAlert(queryID, err.Error())
+		return &alert
+	}
+	if err := h.storage.SetPropertyForChat(property, chat, normalized); err != nil {
+		log.WithFields(fields).WithError(err).Error("Could not set property from settings")
+		alert := tgbotapi.NewCallbackWithAlert(queryID, "Не удалось сохранить настройку")
+		return &alert
+	}
+	h.registry.Changed(property, 0, chat, normalized)
+	h.audit.Record(chat, newSettingsChange(query.From, property, 0, old, normalized, "settings"))
+	answer := tgbotapi.NewCallback(queryID, "Сохранено")
+	return &answer
+}
+
+func (h *settingsHandler) redraw(query tgbotapi.CallbackQuery, text string, markup tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ReplyMarkup = &markup
+	h.OutMsgCh <- edit
+}
+
+func (h *settingsHandler) mainMenu(chat tgbotbase.ChatID) (string, tgbotapi.InlineKeyboardMarkup) {
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(settingsFeatures)+3)
+	for _, f := range settingsFeatures {
+		value, _ := h.storage.GetProperty(f.property, 0, chat)
+		data := settingsPrefix + "time:" + f.property
+		if !f.timed {
+			data = settingsPrefix + "set:" + f.property + ":on"
+			if value != "" {
+				data = settingsPrefix + "off:" + f.property
+			}
+		}
+		label := fmt.Sprintf("%s: %s", f.title, settingsFeatureState(f, value))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, data)))
+	}
+
+	city, _ := h.storage.GetProperty("city", 0, chat)
+	if city == "" {
+		city = "не выбран"
+	}
+	tz, _ := h.storage.GetProperty("timezone", 0, chat)
+	if tz == "" {
+		tz = "как у сервера"
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🏙 Город: "+city, settingsPrefix+"city:city")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🕒 Часовой пояс: "+tz, settingsPrefix+"tz:timezone")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Закрыть", settingsPrefix+"close")))
+
+	return "Настройки чата. Другие город и часовой пояс можно задать через /propsetchat, список свойств: /prophelp", tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (h *settingsHandler) choiceMenu(action, property string) (string, tgbotapi.InlineKeyboardMarkup) {
+	var text string
+	var presets []string
+	switch action {
+	case "time":
+		text = "Во сколько присылать?"
+		presets = settingsTimePresets
+	case "city":
+		text = "Выберите город"
+		presets = settingsCityPresets
+	case "tz":
+		text = "Выберите часовой пояс"
+		presets = settingsTimezonePresets
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	row := make([]tgbotapi.InlineKeyboardButton, 0, 3)
+	for _, preset := range presets {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(preset, settingsPrefix+"set:"+property+":"+preset))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = make([]tgbotapi.InlineKeyboardButton, 0, 3)
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	offLabel := "Выключить"
+	if action != "time" {
+		offLabel = "Сбросить"
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(offLabel, settingsPrefix+"off:"+property),
+		tgbotapi.NewInlineKeyboardButtonData("Назад", settingsPrefix+"main")))
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func settingsFeatureState(f settingsFeature, value string) string {
+	if value == "" {
+		return "выкл"
+	}
+	if !f.timed {
+		return "вкл"
+	}
+	dur, err := time.ParseDuration(value)
+	if err != nil {
+		return value
+	}
+	return fmt.Sprintf("%02d:%02d", int(dur.Hours()), int(dur.Minutes())%60)
+}
@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const settingsExportVersion = 1
+
+const maxSettingsImportSize = 1 << 20
+
+// settingsExport is the document produced by /exportsettings and accepted by /importsettings
+type settingsExport struct {
+	Version    int                      `json:"version"`
+	Chat       int64                    `json:"chat"`
+	Exported   time.Time                `json:"exported"`
+	Properties []settingsExportProperty `json:"properties"`
+	Reminders  []settingsExportReminder `json:"reminders"`
+}
+
+type settingsExportProperty struct {
+	Name  string `json:"name"`
+	User  int    `json:"user"` // 0 for chat-wide properties
+	Value string `json:"value"`
+}
+
+type settingsExportReminder struct {
+	Time    time.Time `json:"time"`
+	ReplyTo int       `json:"reply_to"`
+	Text    string    `json:"text,omitempty"`
+}
+
+// dailyFeatures are the features whose last run is kept in JobRegistry per chat
+var dailyFeatures = []string{kittiesFeature, newsNNFeature, weatherMorningFeature}
+
+type settingsTransferHandler struct {
+	tgbotbase.BaseHandler
+	api         *tgbotapi.BotAPI
+	storage     tgbotbase.PropertyStorage
+	registry    *PropertyRegistry
+	permissions *Permissions
+	reminders   *remindHandler
+	jobs        JobRegistry
+	audit       SettingsLog
+}
+
+var _ tgbotbase.IncomingMessageHandler = &settingsTransferHandler{}
+
+func NewSettingsTransferHandler(api *tgbotapi.BotAPI,
+	storage tgbotbase.PropertyStorage,
+	registry *PropertyRegistry,
+	permissions *Permissions,
+	reminders *remindHandler,
+	jobs JobRegistry,
+	audit SettingsLog) *settingsTransferHandler {
+	return &settingsTransferHandler{
+		api:         api,
+		storage:     storage,
+		registry:    registry,
+		permissions: permissions,
+		reminders:   reminders,
+		jobs:        jobs,
+		audit:       audit}
+}
+
+func (h *settingsTransferHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	return tgbotbase.NewHandlerTriggerFunc(nil, []string{"exportsettings", "importsettings"}, func(msg tgbotapi.Message) bool {
+		return msg.MigrateToChatID != 0
+	})
+}
+
+func (h *settingsTransferHandler) Name() string {
+	return "Settings export/import"
+}
+
+func (h *settingsTransferHandler) HandleOne(msg tgbotapi.Message) {
+	if msg.MigrateToChatID != 0 {
+		h.migrate(tgbotbase.ChatID(msg.Chat.ID), tgbotbase.ChatID(msg.MigrateToChatID))
+		return
+	}
+
+	switch msg.Command() {
+	case "exportsettings":
+		h.export(msg)
+	case "importsettings":
+		reply := tgbotapi.NewMessage(msg.Chat.ID, h.importSettings(msg))
+		reply.BaseChat.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+	}
+}
+
+func (h *settingsTransferHandler) export(msg tgbotapi.Message) {
+	chat := tgbotbase.ChatID(msg.Chat.ID)
+	props, err := h.storage.GetPropertiesForChat(chat)
+	if err != nil {
+		log.WithFields(log.Fields{"chat": chat}).WithError(err).Error("Could not load properties for export")
+		h.OutMsgCh <- tgbotapi.NewMessage(msg.Chat.ID, "Не удалось загрузить настройки")
+		return
+	}
+
+	doc := settingsExport{
+		Version:    settingsExportVersion,
+		Chat:       int64(chat),
+		Exported:   time.Now(),
+		Properties: make([]settingsExportProperty, 0, len(props)),
+		Reminders:  make([]settingsExportReminder, 0)}
+	allUsers := h.permissions.IsOwner(msg.From)
+	for _, p := range props {
+		if p.Value == "" {
+			continue
+		}
+		// settings of other users are not shown to chat members, the same way they cannot be imported
+		if p.User != 0 && !allUsers && (msg.From == nil || int(p.User) != msg.From.ID) {
+			continue
+		}
+		doc.Properties = append(doc.Properties, settingsExportProperty{Name: p.Name, User: int(p.User), Value: p.Value})
+	}
+	for _, r := range h.reminders.remindersOfChat(chat) {
+		doc.Reminders = append(doc.Reminders, settingsExportReminder{Time: r.t, ReplyTo: r.replyTo, Text: r.text})
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.WithFields(log.Fields{"chat": chat}).WithError(err).Error("Could not marshal settings")
+		h.OutMsgCh <- tgbotapi.NewMessage(msg.Chat.ID, "Не удалось выгрузить настройки")
+		return
+	}
+
+	upload := tgbotapi.NewDocumentUpload(msg.Chat.ID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("settings-%d.json", chat),
+		Bytes: data})
+	upload.Caption = fmt.Sprintf("Свойств: %d, напоминаний: %d. Загрузить: ответить на этот файл командой /importsettings", len(doc.Properties), len(doc.Reminders))
+	h.OutMsgCh <- upload
+}
+
+// importSettings applies a document either passed as command arguments or attached to the message the command replies to
+func (h *settingsTransferHandler) importSettings(msg tgbotapi.Message) string {
+	if !h.permissions.canChangeChatIn(msg) {
+		return chatSettingsDenied
+	}
+
+	chat := tgbotbase.ChatID(msg.Chat.ID)
+	data, err := h.importData(msg)
+	if err != nil {
+		log.WithFields(log.Fields{"chat": chat}).WithError(err).Warn("Could not get settings document")
+		return "Нужно ответить командой /importsettings на файл из /exportsettings или передать JSON после команды"
+	}
+
+	var doc settingsExport
+	if err := json.Unmarshal(data, &doc); err != nil {
+		log.WithFields(log.Fields{"chat": chat}).WithError(err).Warn("Could not parse settings document")
+		return fmt.Sprintf("Не удалось разобрать JSON: %s", err)
+	}
+	if doc.Version != settingsExportVersion {
+		return fmt.Sprintf("Неподдерживаемая версия документа: %d", doc.Version)
+	}
+
+	applied := 0
+	rejected := make([]string, 0)
+	for _, p := range doc.Properties {
+		value, err := h.registry.Validate(p.Name, p.Value)
+		if err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", p.Name, err))
+			continue
+		}
+		user := tgbotbase.UserID(p.User)
+		if user != 0 && int(user) != msg.From.ID && !h.permissions.IsOwner(msg.From) {
+			rejected = append(rejected, fmt.Sprintf("%s: настройка другого пользователя", p.Name))
+			continue
+		}
+		old := exactPropertyValue(h.storage, p.Name, user, chat)
+		if err := h.storage.SetPropertyForUserInChat(p.Name, user, chat, value); err != nil {
+			log.WithFields(log.Fields{"chat": chat, "property": p.Name}).WithError(err).Error("Could not import property")
+			rejected = append(rejected, fmt.Sprintf("%s: не удалось сохранить", p.Name))
+			continue
+		}
+		h.registry.Changed(p.Name, user, chat, value)
+		h.audit.Record(chat, newSettingsChange(msg.From, p.Name, user, old, value, "importsettings"))
+		applied++
+	}
+
+	now := time.Now()
+	reminders := 0
+	duplicates := 0
+	for _, r := range doc.Reminders {
+		if r.Time.Before(now) {
+			continue
+		}
+		replyTo := r.ReplyTo
+		if doc.Chat != int64(chat) {
+			// message IDs are only meaningful in the chat they have been exported from
+			replyTo = 0
+		}
+		if !h.reminders.addReminder(Reminder{t: r.Time, chat: chat, replyTo: replyTo, text: r.Text}) {
+			duplicates++
+			continue
+		}
+		reminders++
+	}
+
+	reply := fmt.Sprintf("Загружено свойств: %d, напоминаний: %d", applied, reminders)
+	if duplicates > 0 {
+		reply += fmt.Sprintf("\nУже запланированных напоминаний пропущено: %d", duplicates)
+	}
+	if len(rejected) > 0 {
+		reply += "\nПропущено:\n" + strings.Join(rejected, "\n")
+	}
+	return reply
+}
+
+func (h *settingsTransferHandler) importData(msg tgbotapi.Message) ([]byte, error) {
+	if args := strings.TrimSpace(msg.CommandArguments()); args != "" {
+		return []byte(args), nil
+	}
+	if msg.ReplyToMessage == nil || msg.ReplyToMessage.Document == nil {
+		return nil, fmt.Errorf("no document attached")
+	}
+	if h.api == nil {
+		return nil, fmt.Errorf("no Telegram API to download the document")
+	}
+	if msg.ReplyToMessage.Document.FileSize > maxSettingsImportSize {
+		return nil, fmt.Errorf("document is too big: %d bytes", msg.ReplyToMessage.Document.FileSize)
+	}
+
+	// both the API request and the file URL contain the token, which network errors would expose in logs
+	url, err := h.api.GetFileDirectURL(msg.ReplyToMessage.Document.FileID)
+	if err != nil {
+		return nil, errors.New(tgbotbase.RedactSecrets(err.Error(), h.api.Token))
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, errors.New(tgbotbase.RedactSecrets(err.Error(), h.api.Token))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("document download returned status %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxSettingsImportSize))
+}
+
+// migrate moves all stored state of a group to its new ID after it has been converted into a supergroup
+func (h *settingsTransferHandler) migrate(from, to tgbotbase.ChatID) {
+	fields := log.Fields{"from": from, "to": to}
+	log.WithFields(fields).Info("Chat has migrated, moving its settings")
+
+	props, err := h.storage.GetPropertiesForChat(from)
+	if err != nil {
+		log.WithFields(fields).WithError(err).Error("Could not load properties for migration")
+		return
+	}
+	// the history goes first so that the migration records below end up on top of it
+	h.audit.Migrate(from, to)
+	moved := 0
+	for _, p := range props {
+		if err := h.storage.SetPropertyForUserInChat(p.Name, p.User, to, p.Value); err != nil {
+			log.WithFields(fields).WithField("property", p.Name).WithError(err).Error("Could not move property")
+			continue
+		}
+		if err := h.storage.DeletePropertyForUserInChat(p.Name, p.User, from); err != nil {
+			log.WithFields(fields).WithField("property", p.Name).WithError(err).Error("Could not delete old property")
+		}
+		h.registry.Changed(p.Name, p.User, from, "")
+		h.registry.Changed(p.Name, p.User, to, p.Value)
+		h.audit.Record(to, newSettingsChange(nil, p.Name, p.User, "", p.Value, fmt.Sprintf("migration from %d", from)))
+		moved++
+	}
+
+	for _, feature := range dailyFeatures {
+		if last := h.jobs.LastRun(feature, from); !last.IsZero() {
+			h.jobs.MarkRun(feature, to, last)
+		}
+	}
+
+	reminders := h.reminders.migrateChat(from, to)
+	log.WithFields(fields).WithFields(log.Fields{"properties": moved, "reminders": reminders}).Info("Chat settings have been migrated")
+	h.OutMsgCh <- tgbotapi.NewMessage(int64(to), fmt.Sprintf("Чат стал супергруппой, перенёс настройки: свойств %d, напоминаний %d", moved, reminders))
+}
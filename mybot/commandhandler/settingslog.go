@@ -0,0 +1,82 @@
+package cmd
+
+import "fmt"
+import "strings"
+import "time"
+import "gopkg.in/telegram-bot-api.v4"
+import "github.com/admirallarimda/tgbotbase"
+
+// SettingsChange is a single record of the audit log; empty Old means the property was not set, empty New means it was removed
+type SettingsChange struct {
+	Time      time.Time        `json:"time"`
+	Actor     tgbotbase.UserID `json:"actor"`
+	ActorName string           `json:"actor_name,omitempty"`
+	Property  string           `json:"property"`
+	User      tgbotbase.UserID `json:"user"` // 0 for chat-wide properties
+	Old       string           `json:"old"`
+	New       string           `json:"new"`
+	Source    string           `json:"source"` // command or feature which has made the change
+}
+
+// SettingsLog keeps a bounded log of property changes per chat
+type SettingsLog interface {
+	Record(chat tgbotbase.ChatID, change SettingsChange)
+	Recent(chat tgbotbase.ChatID, n int) []SettingsChange // newest first
+	Migrate(from, to tgbotbase.ChatID)                    // appends the history of 'from' to 'to' as the older part
+}
+
+func newSettingsChange(actor *tgbotapi.User, property string, user tgbotbase.UserID, oldValue, newValue, source string) SettingsChange {
+	change := SettingsChange{
+		Time:     time.Now(),
+		Property: property,
+		User:     user,
+		Old:      oldValue,
+		New:      newValue,
+		Source:   source}
+	if actor != nil {
+		change.Actor = tgbotbase.UserID(actor.ID)
+		change.ActorName = actor.UserName
+		if change.ActorName == "" {
+			change.ActorName = strings.TrimSpace(actor.FirstName + " " + actor.LastName)
+		}
+	}
+	return change
+}
+
+// exactPropertyValue returns the value stored exactly for the user in the chat, without falling back to user-wide or chat-wide values
+func exactPropertyValue(storage tgbotbase.PropertyStorage, name string, user tgbotbase.UserID, chat tgbotbase.ChatID) string {
+	props, err := storage.GetPropertiesForUserInChat(user, chat)
+	if err != nil {
+		return ""
+	}
+	for _, p := range props {
+		if p.Name == name && p.User == user && p.Chat == chat {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+func formatSettingsChange(change SettingsChange, loc *time.Location) string {
+	actor := change.ActorName
+	if actor == "" {
+		actor = fmt.Sprintf("%d", change.Actor)
+	}
+	if change.Actor == 0 {
+		actor = "бот"
+	}
+	oldValue := change.Old
+	if oldValue == "" {
+		oldValue = "—"
+	}
+	newValue := change.New
+	if newValue == "" {
+		newValue = "—"
+	}
+	level := "чат"
+	if change.User != 0 {
+		level = fmt.Sprintf("пользователь %d", change.User)
+	}
+	return fmt.Sprintf("%s %s: %s (%s) %s → %s [%s]",
+		change.Time.In(loc).Format("2006-01-02 15:04"), actor, change.Property, level, oldValue, newValue, change.Source)
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import "encoding/json"
+import "fmt"
+
+import log "github.com/sirupsen/logrus"
+import "github.com/go-redis/redis"
+import "github.com/admirallarimda/tgbotbase"
+
+const settingsLogDepth = 200
+
+type RedisSettingsLog struct {
+	client *redis.Client
+}
+
+func NewRedisSettingsLog(pool tgbotbase.RedisPool) SettingsLog {
+	l := RedisSettingsLog{client: pool.GetConnByName("property")}
+	return &l
+}
+
+func settingsLogKey(chat tgbotbase.ChatID) string {
+	return fmt.Sprintf("settingslog:%d", chat)
+}
+
+func (l *RedisSettingsLog) Record(chat tgbotbase.ChatID, change SettingsChange) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		log.Printf("redisSettingsLog: could not marshal change %+v due to error: %s", change, err)
+		return
+	}
+	key := settingsLogKey(chat)
+	_, err = l.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LPush(key, data)
+		pipe.LTrim(key, 0, settingsLogDepth-1)
+		return nil
+	})
+	if err != nil {
+		log.Printf("redisSettingsLog: could not store change by key '%s' due to error: %s", key, err)
+	}
+}
+
+func (l *RedisSettingsLog) Recent(chat tgbotbase.ChatID, n int) []SettingsChange {
+	key := settingsLogKey(chat)
+	items, err := l.client.LRange(key, 0, int64(n-1)).Result()
+	if err != nil {
+		log.Printf("redisSettingsLog: could not load changes by key '%s' due to error: %s", key, err)
+		return nil
+	}
+	changes := make([]SettingsChange, 0, len(items))
+	for _, item := range items {
+		var change SettingsChange
+		if err := json.Unmarshal([]byte(item), &change); err != nil {
+			log.Printf("redisSettingsLog: could not parse change '%s' due to error: %s", item, err)
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func (l *RedisSettingsLog) Migrate(from, to tgbotbase.ChatID) {
+	fromKey := settingsLogKey(from)
+	toKey := settingsLogKey(to)
+	items, err := l.client.LRange(fromKey, 0, -1).Result()
+	if err != nil {
+		log.Printf("redisSettingsLog: could not load changes by key '%s' due to error: %s", fromKey, err)
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+	values := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		values = append(values, item)
+	}
+	_, err = l.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.RPush(toKey, values...)
+		pipe.LTrim(toKey, 0, settingsLogDepth-1)
+		pipe.Del(fromKey)
+		return nil
+	})
+	if err != nil {
+		log.Printf("redisSettingsLog: could not move changes from '%s' to '%s' due to error: %s", fromKey, toKey, err)
+	}
+}
@@ -4,15 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
 
-	"github.com/go-redis/redis"
 	tgbotapi "gopkg.in/telegram-bot-api.v4"
 )
 
@@ -20,65 +20,115 @@ var reToday *regexp.Regexp = regexp.MustCompile("сегодня")
 var reDayAfterTomorrow *regexp.Regexp = regexp.MustCompile("послезавтра")
 var reTomorrow *regexp.Regexp = regexp.MustCompile("завтра")
 
-func requestData(reqType string, cityId int64, apiKey string) ([]byte, error) {
-	weather_url := fmt.Sprintf("http://api.openweathermap.org/data/2.5/%s?id=%d&APPID=%s&lang=ru&units=metric", reqType,
+func requestData(reqType string, cityId int64, apiKey string, opts weatherOptions) ([]byte, error) {
+	weather_url := fmt.Sprintf("http://api.openweathermap.org/data/2.5/%s?id=%d&APPID=%s&lang=%s&units=%s", reqType,
 		cityId,
-		apiKey)
-	log.Printf("Sending weather request using url: %s", weather_url)
+		apiKey,
+		url.QueryEscape(opts.DescriptionLang),
+		url.QueryEscape(opts.Units))
+	return requestURL(weather_url)
+}
+
+// requestURL performs GET request to a weather service, converting failures into weather errors
+func requestURL(weather_url string) ([]byte, error) {
+	logged_url := redactURL(weather_url)
+	log.Printf("Sending weather request using url: %s", logged_url)
 
 	resp, err := http.Get(weather_url)
 	if err != nil {
-		log.Printf("Could not get data from '%s' due to error: %s", weather_url, err)
-		return []byte{}, err
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = logged_url
+		}
+		log.Printf("Could not get data from '%s' due to error: %s", logged_url, err)
+		return []byte{}, newWeatherError(weatherErrNetwork, err)
 	}
 	defer resp.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Could not read response body from '%s' due to error: %s", weather_url, err)
-		return []byte{}, err
+		log.Printf("Could not read response body from '%s' due to error: %s", logged_url, err)
+		return []byte{}, newWeatherError(weatherErrNetwork, err)
 	}
 
 	log.Printf("Weather response: %s", string(bodyBytes))
 
+	if err := checkResponseStatus(resp.StatusCode, bodyBytes); err != nil {
+		return []byte{}, err
+	}
 	return bodyBytes, nil
 }
 
+var reAPIKeyParam = regexp.MustCompile("(?i)([?&]appid=)[^&]*")
+
+// redactURL hides the API key passed as APPID query parameter
+func redactURL(raw string) string {
+	return reAPIKeyParam.ReplaceAllString(raw, "${1}"+tgbotbase.MaskedSecret)
+}
+
+// checkResponseStatus converts non-successful HTTP statuses of OpenWeatherMap into weather errors
+func checkResponseStatus(status int, body []byte) error {
+	if status == http.StatusOK {
+		return nil
+	}
+
+	err := fmt.Errorf("status %d, response: %s", status, string(body))
+	switch {
+	case status == http.StatusUnauthorized:
+		return newWeatherError(weatherErrInvalidKey, err)
+	case status == http.StatusNotFound:
+		return newWeatherError(weatherErrUnknownCity, err)
+	case status == http.StatusTooManyRequests:
+		return newWeatherError(weatherErrQuotaExceeded, err)
+	case status >= http.StatusInternalServerError:
+		return newWeatherError(weatherErrNetwork, err)
+	}
+	return newWeatherError(weatherErrMalformed, err)
+}
+
+var reInCities = regexp.MustCompile("(в|in) ([\\wA-Za-zА-Яа-яЁё-]+(?:(?:\\s*,\\s*|\\s+и\\s+|\\s+and\\s+)[\\wA-Za-zА-Яа-яЁё-]+)*)")
+var reCitySeparator = regexp.MustCompile("\\s*,\\s*|\\s+и\\s+|\\s+and\\s+")
+
+// determineCities returns all cities mentioned in the text; empty result means that the city should be taken from properties
+func determineCities(text string) []string {
+	if !reInCities.MatchString(text) {
+		return nil
+	}
+	log.Printf("Message '%s' matches 'in city' regexp %s", text, reInCities)
+	matches := reInCities.FindStringSubmatch(text)
+
+	cities := make([]string, 0)
+	for _, city := range reCitySeparator.Split(matches[2], -1) {
+		if city == "" || reToday.MatchString(city) || reTomorrow.MatchString(city) {
+			continue
+		}
+		cities = append(cities, city)
+	}
+	return cities
+}
+
 func (h *weatherHandler) determineCity(msg tgbotapi.Message) (int64, error) {
-	reInCity := regexp.MustCompile("(в|in) ([\\wA-Za-zА-Яа-я]+)")
-	text := msg.Text
-	if reInCity.MatchString(text) {
-		log.Printf("Message '%s' matches 'in city' regexp %s", text, reInCity)
-		matches := reInCity.FindStringSubmatch(text)
-		city := matches[2]
-		return getCityIDByName(h.redisconn, city)
+	if cities := determineCities(msg.Text); len(cities) > 0 {
+		return getCityIDByName(h.cities, cities[0])
 	}
 
-	return getCityIDFromProperty(h.properties, h.redisconn, tgbotbase.UserID(msg.From.ID), tgbotbase.ChatID(msg.Chat.ID))
+	return getCityIDFromProperty(h.properties, h.cities, tgbotbase.UserID(msg.From.ID), tgbotbase.ChatID(msg.Chat.ID))
 }
 
-func getCityIDFromProperty(props tgbotbase.PropertyStorage, conn *redis.Client, userID tgbotbase.UserID, chatID tgbotbase.ChatID) (int64, error) {
+func getCityIDFromProperty(props tgbotbase.PropertyStorage, cities CityIndex, userID tgbotbase.UserID, chatID tgbotbase.ChatID) (int64, error) {
 	city, err := props.GetProperty("city", userID, chatID)
 	if err != nil {
 		log.Printf("Could not get weather city property due to error: %s", err)
-		return 0, err
+		return 0, newWeatherError(weatherErrNetwork, err)
 	}
 
-	return getCityIDByName(conn, city)
+	return getCityIDByName(cities, city)
 }
 
-func getCityIDByName(conn *redis.Client, city string) (int64, error) {
-	city = strings.ToLower(city)
+func getCityIDByName(cities CityIndex, city string) (int64, error) {
+	city = strings.ToLower(strings.TrimSpace(city))
 
-	key := fmt.Sprintf("openweathermap:city:%s", city)
-	result := conn.HGet(key, "id")
-	if result.Err() != nil {
-		log.Printf("Could not HGet for key '%s', error: %s", key, result.Err())
-		return 0, result.Err()
-	}
-
-	cityId, err := result.Int64()
+	cityId, err := cities.CityID(city)
 	if err != nil {
-		log.Printf("Could not convert ID for key '%s' into int, error: %s", key, err)
+		log.Printf("Could not get ID of city '%s', error: %s", city, err)
 		return 0, err
 	}
 	log.Printf("City ID for %s is %d", city, cityId)
@@ -114,7 +164,10 @@ func determineDate(text string) *time.Time {
 type weatherData struct {
 	Cod  int
 	Main struct {
-		Temp float64
+		Temp       float64
+		Feels_like float64
+		Pressure   float64 // hPa
+		Humidity   int
 	}
 	Name    string
 	Weather []struct {
@@ -122,6 +175,20 @@ type weatherData struct {
 	}
 	Wind struct {
 		Speed float32
+		Deg   float64
+	}
+	Visibility int // meters
+	Clouds     struct {
+		All int
+	}
+	Sys struct {
+		Sunrise int64
+		Sunset  int64
+	}
+	Timezone int // shift from UTC in seconds
+	Coord    struct {
+		Lat float64
+		Lon float64
 	}
 }
 
@@ -130,6 +197,7 @@ type forecastData struct {
 		Name string
 	}
 	List []struct {
+		Dt      int64
 		DT_txt  string
 		Weather []struct {
 			Description string
@@ -137,32 +205,83 @@ type forecastData struct {
 		Main struct {
 			Temp float32
 		}
+		Rain struct {
+			Volume float64 `json:"3h"` // mm
+		}
+		Snow struct {
+			Volume float64 `json:"3h"` // mm
+		}
 	}
 }
 
-func getCurrentWeather(token string, cityId int64) (string, error) {
-	bytes, err := requestData("weather", cityId, token)
+func (data weatherData) description() string {
+	if len(data.Weather) == 0 {
+		return ""
+	}
+	return data.Weather[0].Description
+}
+
+func requestCurrentWeather(token string, cityId int64, opts weatherOptions) (*weatherData, error) {
+	bytes, err := requestData("weather", cityId, token, opts)
 	if err != nil {
-		return "", nil
+		return nil, err
 	}
 
 	weather_data := weatherData{}
 	err = json.Unmarshal(bytes, &weather_data)
-	//err = json.NewDecoder(resp.Body).Decode(&weather_data)
-	if err != nil || weather_data.Cod != 200 {
-		return "Я не смог распарсить погоду :(", err
+	if err != nil {
+		return nil, newWeatherError(weatherErrMalformed, err)
+	}
+	if weather_data.Cod != 200 {
+		return nil, newWeatherError(weatherErrMalformed, fmt.Errorf("unexpected weather response code %d", weather_data.Cod))
+	}
+	return &weather_data, nil
+}
+
+// getCurrentWeather returns current weather description; if history is not nil, weather is recorded and compared with yesterday
+func getCurrentWeather(token string, cityId int64, opts weatherOptions, history *WeatherHistory) (string, error) {
+	data, err := requestCurrentWeather(token, cityId, opts)
+	if err != nil {
+		return "", err
+	}
+	weather_data := *data
+
+	comparison := ""
+	if history != nil {
+		now := time.Now()
+		comparison = history.compareWithYesterday(cityId, data, opts, now)
+		history.record(cityId, data, opts, now)
 	}
 
-	weather_msg := fmt.Sprintf("Сейчас в %s: %s, %.1f градусов, дует ветер %.0f м/с", weather_data.Name,
-		weather_data.Weather[0].Description,
-		weather_data.Main.Temp,
-		weather_data.Wind.Speed)
+	weather_msg := fmt.Sprintf("Сейчас в %s: %s, %.1f%s, дует ветер %.0f %s", weather_data.Name,
+		weather_data.description(),
+		weather_data.Main.Temp, opts.tempUnit(),
+		weather_data.Wind.Speed, opts.speedUnit())
+	if opts.Detailed {
+		weather_msg += weatherDetails(weather_data, opts)
+	}
+	if comparison != "" {
+		weather_msg += "\n" + comparison
+	}
 	return weather_msg, nil
 }
 
-func getForecast(token string, cityId int64, date time.Time) (string, error) {
+func weatherDetails(data weatherData, opts weatherOptions) string {
+	details := fmt.Sprintf(" (%s)", compassPoint(data.Wind.Deg))
+	details += fmt.Sprintf("\nОщущается как %.1f%s", data.Main.Feels_like, opts.tempUnit())
+	details += fmt.Sprintf("\nВлажность %d%%, давление %.0f мм рт. ст.", data.Main.Humidity, data.Main.Pressure*hPaToMmHg)
+	details += fmt.Sprintf("\nВидимость %s, облачность %d%%", formatVisibility(data.Visibility), data.Clouds.All)
+	if data.Sys.Sunrise != 0 && data.Sys.Sunset != 0 {
+		details += fmt.Sprintf("\nВосход %s, закат %s",
+			cityLocalTime(data.Sys.Sunrise, data.Timezone).Format(timeFormat_Out_Time),
+			cityLocalTime(data.Sys.Sunset, data.Timezone).Format(timeFormat_Out_Time))
+	}
+	return details
+}
+
+func getForecast(token string, cityId int64, date time.Time, opts weatherOptions) (string, error) {
 	log.Printf("Checking for upcoming weather in city %d", cityId)
-	bytes, err := requestData("forecast", cityId, token)
+	bytes, err := requestData("forecast", cityId, token, opts)
 	if err != nil {
 		return "", err
 	}
@@ -171,7 +290,7 @@ func getForecast(token string, cityId int64, date time.Time) (string, error) {
 	err = json.Unmarshal(bytes, &forecast_data)
 	//err = json.NewDecoder(resp.Body).Decode(&weather_data)
 	if err != nil {
-		return "Я не смог распарсить прогноз :(", err
+		return "", newWeatherError(weatherErrMalformed, err)
 	}
 
 	now := time.Now()
@@ -199,13 +318,17 @@ func getForecast(token string, cityId int64, date time.Time) (string, error) {
 			log.Printf("Skipping date: %s", t)
 			continue
 		}
-		log.Printf("Forecast: %s,t = %.1f, %s", t, val.Main.Temp, val.Weather[0].Description)
-		forecasts = append(forecasts, fmt.Sprintf("%s: %.1f\u2103, %s", t.Format(timeFormat_Out_Time), val.Main.Temp, val.Weather[0].Description))
+		description := ""
+		if len(val.Weather) > 0 {
+			description = val.Weather[0].Description
+		}
+		log.Printf("Forecast: %s,t = %.1f, %s", t, val.Main.Temp, description)
+		forecasts = append(forecasts, fmt.Sprintf("%s: %.1f%s, %s", t.Format(timeFormat_Out_Time), val.Main.Temp, opts.tempUnit(), description))
 	}
 
 	if len(forecasts) == 0 {
 		log.Printf("Something went wrong - no forecast")
-		return "Я не смог сделать прогноз :(", err
+		return "Я не смог сделать прогноз :(", nil
 	}
 
 	forecast_msg := fmt.Sprintf("Прогнозирую на %s в %s:\n", date.Format(timeFormat_Out_Date), forecast_data.City.Name)
@@ -225,25 +348,33 @@ var weatherWords = []string{"^погода", "^weather"}
 
 type weatherHandler struct {
 	tgbotbase.BaseHandler
-	token      string
-	redisconn  *redis.Client
-	properties tgbotbase.PropertyStorage
+	token       string
+	cities      CityIndex
+	properties  tgbotbase.PropertyStorage
+	morning     *WeatherMorningHandler
+	air         AirQualityProvider
+	history     *WeatherHistory
+	permissions *Permissions
+	audit       SettingsLog
 }
 
-func NewWeatherHandler(token string, pool tgbotbase.RedisPool, properties tgbotbase.PropertyStorage) tgbotbase.IncomingMessageHandler {
+// NewWeatherHandler creates the handler of weather requests; history may be nil
+func NewWeatherHandler(token string, cities CityIndex, properties tgbotbase.PropertyStorage, morning *WeatherMorningHandler, air AirQualityProvider, history *WeatherHistory, permissions *Permissions, audit SettingsLog) tgbotbase.IncomingMessageHandler {
 	handler := weatherHandler{}
 	handler.token = token
-	handler.redisconn = pool.GetConnByName("openweathermap")
+	handler.cities = cities
 	handler.properties = properties
-	if handler.redisconn == nil {
-		log.Panicf("Could not get connection to Redis")
-	}
+	handler.morning = morning
+	handler.air = air
+	handler.permissions = permissions
+	handler.audit = audit
+	handler.history = history
 	return &handler
 }
 
 func (h *weatherHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) tgbotbase.HandlerTrigger {
 	h.OutMsgCh = outMsgCh
-	return tgbotbase.NewHandlerTrigger(regexp.MustCompile("^погода"), nil)
+	return tgbotbase.NewHandlerTrigger(regexp.MustCompile("^(погода|воздух|air)([^\\pL\\pN]|$)"), []string{"weather", "air"})
 }
 
 func (h *weatherHandler) Name() string {
@@ -251,25 +382,48 @@ func (h *weatherHandler) Name() string {
 }
 
 func (h *weatherHandler) HandleOne(msg tgbotapi.Message) {
+	if msg.Command() == "weather" && h.handleSubcommand(msg) {
+		return
+	}
+
 	text := msg.Text
+	if msg.Command() == "air" || reAir.MatchString(strings.ToLower(text)) {
+		h.handleAir(msg)
+		return
+	}
 
 	date := determineDate(text)
+	opts := loadWeatherOptions(h.properties, tgbotbase.UserID(msg.From.ID), tgbotbase.ChatID(msg.Chat.ID))
+	if cities := determineCities(text); len(cities) > 1 {
+		h.OutMsgCh <- h.multiCityReply(msg, cities, date, opts)
+		return
+	}
+
 	cityID, err := h.determineCity(msg)
 	if err != nil {
-		log.Printf("Could not determine city from message '%s' due to error: '%s'", text, err)
+		logWeatherError(err, log.Fields{"text": text, "chat": msg.Chat.ID})
 
-		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Не смог распарсить город :("))
+		reply := tgbotapi.NewMessage(msg.Chat.ID, weatherErrorReply(err))
 		reply.BaseChat.ReplyToMessageID = msg.MessageID
 		h.OutMsgCh <- reply
 		return
 	}
 
+	if reChart.MatchString(text) {
+		h.sendForecastChart(msg, cityID, opts)
+		return
+	}
+
 	var replyMsg string
 
 	if date == nil {
-		replyMsg, err = getCurrentWeather(h.token, cityID)
+		replyMsg, err = getCurrentWeather(h.token, cityID, opts, h.history)
 	} else {
-		replyMsg, err = getForecast(h.token, cityID, *date)
+		replyMsg, err = getForecast(h.token, cityID, *date, opts)
+	}
+	if err != nil {
+		logWeatherError(err, log.Fields{"text": text, "chat": msg.Chat.ID, "city": cityID})
+		replyMsg = weatherErrorReply(err)
 	}
 
 	reply := tgbotapi.NewMessage(msg.Chat.ID, replyMsg)
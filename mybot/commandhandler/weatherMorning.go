@@ -1,88 +1,117 @@
 package cmd
 
 import (
-	"log"
 	"time"
 
 	"github.com/admirallarimda/tgbotbase"
-	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/telegram-bot-api.v4"
 )
 
-type weatherMorningHandler struct {
+// WeatherMorningHandler sends the daily forecast to chats having chat-level 'weatherTime'
+type WeatherMorningHandler struct {
 	tgbotbase.BaseHandler
-	props tgbotbase.PropertyStorage
-	conn  *redis.Client
-	cron  tgbotbase.Cron
-	token string
+	props  tgbotbase.PropertyStorage
+	cities CityIndex
+	cron   tgbotbase.Cron
+	air    AirQualityProvider
+	token  string
+	daily  *dailyScheduler
+
+	history         *WeatherHistory
+	historySampling time.Duration // 0 if history is recorded only from weather requests
 }
 
-var _ tgbotbase.BackgroundMessageHandler = &weatherMorningHandler{}
+var _ tgbotbase.BackgroundMessageHandler = &WeatherMorningHandler{}
+
+const weatherMorningFeature = "weatherMorning"
 
 func NewWeatherMorningHandler(cron tgbotbase.Cron,
 	props tgbotbase.PropertyStorage,
-	pool tgbotbase.RedisPool,
-	token string) tgbotbase.BackgroundMessageHandler {
-	h := &weatherMorningHandler{
-		props: props,
-		conn:  pool.GetConnByName("openweathermap"),
-		cron:  cron,
-		token: token}
+	cities CityIndex,
+	jobs JobRegistry,
+	grace time.Duration,
+	air AirQualityProvider,
+	token string,
+	history *WeatherHistory,
+	historySampling time.Duration) *WeatherMorningHandler {
+	h := &WeatherMorningHandler{
+		props:  props,
+		cities: cities,
+		cron:   cron,
+		air:    air,
+		token:  token,
+
+		history:         history,
+		historySampling: historySampling}
+	h.daily = newDailyScheduler(weatherMorningFeature, "weatherTime", props, cron, jobs, grace, h.sendForecast)
 	return h
 }
 
-func (h *weatherMorningHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) {
+func (h *WeatherMorningHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) {
 	h.OutMsgCh = outMsgCh
 }
 
-func (h *weatherMorningHandler) Run() {
-	// TODO: same as for kitties. Write common func
-	now := time.Now()
-	props, _ := h.props.GetEveryHavingProperty("weatherTime")
-	for _, prop := range props {
-		if (prop.User != 0) && (tgbotbase.ChatID(prop.User) != prop.Chat) {
-			log.Printf("Morning weather: Skipping special setting for user %d in chat %d", prop.User, prop.Chat)
-			continue
-		}
-		dur, err := time.ParseDuration(prop.Value)
-		if err != nil {
-			log.Printf("Could not parse duration %s for chat %d due to error: %s", prop.Value, prop.Chat, err)
-			continue
-		}
-
-		cityID, err := getCityIDFromProperty(h.props, h.conn, prop.User, prop.Chat)
-		if err != nil {
-			log.Printf("Could not get city ID from property for user '%d' city '%d' due to error: %s", prop.User, prop.Chat, err)
-			continue
-		}
-
-		when := tgbotbase.CalcNextTimeFromMidnight(now, dur)
-		job := weatherJob{
-			cityID: cityID,
-			chatID: prop.Chat,
-			token:  h.token}
-		job.OutMsgCh = h.OutMsgCh
-		h.cron.AddJob(when, &job)
+func (h *WeatherMorningHandler) Run() {
+	if h.history != nil && h.historySampling > 0 {
+		h.cron.AddJob(time.Now(), &weatherHistoryJob{
+			props:    h.props,
+			cities:   h.cities,
+			history:  h.history,
+			token:    h.token,
+			sampling: h.historySampling})
 	}
+	h.daily.scheduleAll()
 }
 
-func (h *weatherMorningHandler) Name() string {
-	return "weather at morning"
+// PropertyChanged reschedules morning weather of the chat after 'weatherTime' has been changed
+func (h *WeatherMorningHandler) PropertyChanged(chat tgbotbase.ChatID, value string) {
+	h.daily.PropertyChanged(chat, value)
 }
 
-type weatherJob struct {
-	tgbotbase.BaseHandler
-	cityID int64
-	chatID tgbotbase.ChatID
-	token  string
+// schedule replaces the morning weather job of the chat with a new one starting at 'when'
+func (h *WeatherMorningHandler) schedule(chat tgbotbase.ChatID, when time.Time) {
+	h.daily.schedule(chat, when)
+}
+
+// unschedule stops morning weather delivery to the chat
+func (h *WeatherMorningHandler) unschedule(chat tgbotbase.ChatID) {
+	h.daily.unschedule(chat)
 }
 
-var _ tgbotbase.CronJob = &weatherJob{}
+func (h *WeatherMorningHandler) Name() string {
+	return "weather at morning"
+}
 
-func (job *weatherJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
-	defer cron.AddJob(scheduledWhen.Add(24*time.Hour), job)
+// sendForecast uses chat-level settings only, the same ones the subscription is shown with
+func (h *WeatherMorningHandler) sendForecast(chat tgbotbase.ChatID) bool {
+	user := chatLevel(chat)
+	cityID, err := getCityIDFromProperty(h.props, h.cities, user, chat)
+	if err != nil {
+		logWeatherError(err, log.Fields{"job": weatherMorningFeature, "user": user, "chat": chat})
+		return false
+	}
 
-	if msg, err := getForecast(job.token, job.cityID, time.Now()); err == nil {
-		job.OutMsgCh <- tgbotapi.NewMessage(int64(job.chatID), msg)
+	opts := loadWeatherOptions(h.props, user, chat)
+	msg, err := getForecast(h.token, cityID, time.Now(), opts)
+	if err != nil {
+		logWeatherError(err, log.Fields{"job": weatherMorningFeature, "chat": chat, "city": cityID})
+		return false
+	}
+	if airEnabled, _ := h.props.GetProperty("weatherAir", user, chat); airEnabled == "on" && h.air != nil {
+		if airText, err := getAirQuality(h.air, h.cities, h.token, cityID); err == nil {
+			msg += "\n" + airText
+		} else {
+			logWeatherError(err, log.Fields{"job": weatherMorningFeature, "chat": chat, "city": cityID, "air": true})
+		}
 	}
+	h.OutMsgCh <- tgbotapi.NewMessage(int64(chat), msg)
+	return true
+}
+
+// WeatherMorningProperties declares properties used by morning forecast
+func WeatherMorningProperties() []PropertyDef {
+	return []PropertyDef{
+		dailyTimeProperty("weatherTime", "время утреннего прогноза погоды (задавать для чата)"),
+		enumProperty("weatherAir", "off", "добавлять качество воздуха в утренний прогноз", "on", "off")}
 }
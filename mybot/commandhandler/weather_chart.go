@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+var reChart = regexp.MustCompile("график|chart")
+var reChartHours = regexp.MustCompile("(\\d+) *(ч|h)")
+
+const (
+	chartMinHours     = 24
+	chartMaxHours     = 120
+	chartDefaultHours = 48
+
+	chartWidth        = 800
+	chartHeight       = 400
+	chartMarginLeft   = 60
+	chartMarginRight  = 50
+	chartMarginTop    = 20
+	chartMarginBottom = 30
+)
+
+var (
+	chartColorBackground = color.RGBA{255, 255, 255, 255}
+	chartColorGrid       = color.RGBA{225, 225, 225, 255}
+	chartColorDay        = color.RGBA{150, 150, 150, 255}
+	chartColorText       = color.RGBA{60, 60, 60, 255}
+	chartColorTemp       = color.RGBA{220, 50, 40, 255}
+	chartColorPrecip     = color.RGBA{70, 130, 220, 255}
+)
+
+// determineChartHours extracts requested chart length like '72ч' from the text, keeping it within supported limits
+func determineChartHours(text string) int {
+	hours := chartDefaultHours
+	if matches := reChartHours.FindStringSubmatch(text); matches != nil {
+		hours, _ = strconv.Atoi(matches[1])
+	}
+	if hours < chartMinHours {
+		hours = chartMinHours
+	}
+	if hours > chartMaxHours {
+		hours = chartMaxHours
+	}
+	return hours
+}
+
+type chartPoint struct {
+	t      time.Time
+	temp   float64
+	precip float64 // mm for 3 hours
+}
+
+func forecastChartPoints(data forecastData, from time.Time, hours int) []chartPoint {
+	till := from.Add(time.Duration(hours) * time.Hour)
+	points := make([]chartPoint, 0, len(data.List))
+	for _, val := range data.List {
+		t := time.Unix(val.Dt, 0).Local()
+		if t.Before(from.Add(-3*time.Hour)) || t.After(till) {
+			continue
+		}
+		points = append(points, chartPoint{
+			t:      t,
+			temp:   float64(val.Main.Temp),
+			precip: val.Rain.Volume + val.Snow.Volume})
+	}
+	return points
+}
+
+func getForecastChart(token string, cityId int64, hours int, opts weatherOptions) ([]byte, string, error) {
+	bytes, err := requestData("forecast", cityId, token, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	forecast_data := forecastData{}
+	if err = json.Unmarshal(bytes, &forecast_data); err != nil {
+		return nil, "", newWeatherError(weatherErrMalformed, err)
+	}
+
+	points := forecastChartPoints(forecast_data, time.Now(), hours)
+	img, err := renderForecastChart(points)
+	if err != nil {
+		return nil, "", err
+	}
+
+	caption := fmt.Sprintf("%s, %d ч: температура (%s) красным, осадки (мм за 3 ч) синим",
+		forecast_data.City.Name, hours, opts.tempUnit())
+	return img, caption, nil
+}
+
+func (h *weatherHandler) sendForecastChart(msg tgbotapi.Message, cityID int64, opts weatherOptions) {
+	hours := determineChartHours(msg.Text)
+	img, caption, err := getForecastChart(h.token, cityID, hours, opts)
+	if err != nil {
+		logWeatherError(err, log.Fields{"chart": hours, "chat": msg.Chat.ID, "city": cityID})
+		replyText := "Я не смог нарисовать прогноз :("
+		var werr *weatherError
+		if errors.As(err, &werr) {
+			replyText = weatherErrorReply(err)
+		}
+		reply := tgbotapi.NewMessage(msg.Chat.ID, replyText)
+		reply.BaseChat.ReplyToMessageID = msg.MessageID
+		h.OutMsgCh <- reply
+		return
+	}
+
+	picMsg := tgbotapi.NewPhotoUpload(msg.Chat.ID, tgbotapi.FileBytes{Name: "forecast.png", Bytes: img})
+	picMsg.Caption = caption
+	picMsg.BaseChat.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- picMsg
+}
+
+// niceStep returns a round step (1, 2, 5 multiplied by power of 10) dividing the span into approximately n parts
+func niceStep(span float64, n int) float64 {
+	if span <= 0 {
+		return 1
+	}
+	raw := span / float64(n)
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if m*magnitude >= raw {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// renderForecastChart draws temperature as a line and precipitation as bars, returning PNG data
+func renderForecastChart(points []chartPoint) ([]byte, error) {
+	if len(points) < 2 {
+		return nil, errors.New("not enough forecast points for a chart")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartColorBackground}, image.ZP, draw.Src)
+
+	left, right := chartMarginLeft, chartWidth-chartMarginRight
+	top, bottom := chartMarginTop, chartHeight-chartMarginBottom
+
+	minTemp, maxTemp := points[0].temp, points[0].temp
+	maxPrecip := 1.0
+	for _, p := range points {
+		minTemp = math.Min(minTemp, p.temp)
+		maxTemp = math.Max(maxTemp, p.temp)
+		maxPrecip = math.Max(maxPrecip, p.precip)
+	}
+	tempStep := niceStep(maxTemp-minTemp, 5)
+	minTemp = math.Floor(minTemp/tempStep) * tempStep
+	maxTemp = math.Ceil(maxTemp/tempStep) * tempStep
+	if maxTemp == minTemp {
+		maxTemp += tempStep
+	}
+	maxPrecip = math.Ceil(maxPrecip)
+
+	start, end := points[0].t, points[len(points)-1].t
+	xOf := func(t time.Time) int {
+		return left + int(float64(right-left)*t.Sub(start).Seconds()/end.Sub(start).Seconds())
+	}
+	yOfTemp := func(temp float64) int {
+		return bottom - int(float64(bottom-top)*(temp-minTemp)/(maxTemp-minTemp))
+	}
+	yOfPrecip := func(precip float64) int {
+		return bottom - int(float64(bottom-top)*precip/maxPrecip)
+	}
+
+	// temperature grid with labels on the left
+	for temp := minTemp; temp <= maxTemp+tempStep/2; temp += tempStep {
+		y := yOfTemp(temp)
+		drawHLine(img, left, right, y, chartColorGrid)
+		label := strconv.FormatFloat(temp, 'f', -1, 64)
+		drawText(img, left-textWidth(label)-6, y-chartGlyphHeight/2, label, chartColorTemp)
+	}
+	// precipitation scale on the right
+	drawText(img, right+6, yOfPrecip(maxPrecip)-chartGlyphHeight/2, strconv.FormatFloat(maxPrecip, 'f', -1, 64), chartColorPrecip)
+	drawText(img, right+6, yOfPrecip(0)-chartGlyphHeight/2, "0", chartColorPrecip)
+
+	// vertical lines every 6 hours with darker ones at midnight
+	tick := time.Date(start.Year(), start.Month(), start.Day(), start.Hour()/6*6, 0, 0, 0, start.Location())
+	for ; !tick.After(end); tick = tick.Add(6 * time.Hour) {
+		if tick.Before(start) {
+			continue
+		}
+		x := xOf(tick)
+		lineColor := chartColorGrid
+		if tick.Hour() == 0 {
+			lineColor = chartColorDay
+		}
+		drawVLine(img, x, top, bottom, lineColor)
+		label := tick.Format("15")
+		drawText(img, x-textWidth(label)/2, bottom+8, label, chartColorText)
+	}
+
+	// precipitation bars
+	barWidth := (right - left) / len(points) / 2
+	if barWidth < 2 {
+		barWidth = 2
+	}
+	for _, p := range points {
+		if p.precip <= 0 {
+			continue
+		}
+		x := xOf(p.t)
+		bar := image.Rect(x-barWidth/2, yOfPrecip(p.precip), x+barWidth/2+1, bottom).Intersect(image.Rect(left, top, right+1, bottom))
+		draw.Draw(img, bar, &image.Uniform{chartColorPrecip}, image.ZP, draw.Src)
+	}
+
+	// temperature line
+	for i := 1; i < len(points); i++ {
+		drawLine(img, xOf(points[i-1].t), yOfTemp(points[i-1].temp), xOf(points[i].t), yOfTemp(points[i].temp), chartColorTemp)
+	}
+
+	drawHLine(img, left, right, bottom, chartColorText)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawHLine(img *image.RGBA, x0, x1, y int, c color.Color) {
+	for x := x0; x <= x1; x++ {
+		img.Set(x, y, c)
+	}
+}
+
+func drawVLine(img *image.RGBA, x, y0, y1 int, c color.Color) {
+	for y := y0; y <= y1; y++ {
+		img.Set(x, y, c)
+	}
+}
+
+// drawLine draws a 2px thick line using Bresenham's algorithm
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx := int(math.Abs(float64(x1 - x0)))
+	dy := -int(math.Abs(float64(y1 - y0)))
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		img.Set(x0+1, y0, c)
+		img.Set(x0, y0+1, c)
+		img.Set(x0+1, y0+1, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+const (
+	chartGlyphScale  = 2
+	chartGlyphWidth  = 3 * chartGlyphScale
+	chartGlyphHeight = 5 * chartGlyphScale
+	chartGlyphSpace  = chartGlyphScale
+)
+
+// 3x5 bitmap glyphs for chart labels, each row is 3 bits starting from the left
+var chartGlyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 2, 2},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'-': {0, 0, 7, 0, 0},
+	'.': {0, 0, 0, 0, 2},
+}
+
+func textWidth(s string) int {
+	n := len([]rune(s))
+	if n == 0 {
+		return 0
+	}
+	return n*(chartGlyphWidth+chartGlyphSpace) - chartGlyphSpace
+}
+
+func drawText(img *image.RGBA, x, y int, s string, c color.Color) {
+	for _, r := range s {
+		glyph, found := chartGlyphs[r]
+		if found {
+			for row := 0; row < 5; row++ {
+				for col := 0; col < 3; col++ {
+					if glyph[row]&(4>>uint(col)) == 0 {
+						continue
+					}
+					draw.Draw(img, image.Rect(x+col*chartGlyphScale, y+row*chartGlyphScale, x+(col+1)*chartGlyphScale, y+(row+1)*chartGlyphScale),
+						&image.Uniform{c}, image.ZP, draw.Src)
+				}
+			}
+		}
+		x += chartGlyphWidth + chartGlyphSpace
+	}
+}
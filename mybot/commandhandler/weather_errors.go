@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type weatherErrorKind int
+
+const (
+	weatherErrNetwork weatherErrorKind = iota
+	weatherErrUnknownCity
+	weatherErrInvalidKey
+	weatherErrQuotaExceeded
+	weatherErrMalformed
+)
+
+var weatherErrorKindNames = map[weatherErrorKind]string{
+	weatherErrNetwork:       "network",
+	weatherErrUnknownCity:   "unknown city",
+	weatherErrInvalidKey:    "invalid api key",
+	weatherErrQuotaExceeded: "quota exceeded",
+	weatherErrMalformed:     "malformed response",
+}
+
+var weatherErrorReplies = map[weatherErrorKind]string{
+	weatherErrNetwork:       "Сервис погоды сейчас недоступен, попробуй чуть позже :(",
+	weatherErrUnknownCity:   "Не знаю такого города :( Укажи его так: 'погода в Москва' или настрой: /weather subscribe 07:30 Москва",
+	weatherErrInvalidKey:    "У меня сломался ключ от сервиса погоды, владелец уже должен это чинить :(",
+	weatherErrQuotaExceeded: "Слишком много вопросов о погоде, сервис просит подождать. Попробуй через пару минут",
+	weatherErrMalformed:     "Я не смог распарсить погоду :(",
+}
+
+func (k weatherErrorKind) String() string {
+	return weatherErrorKindNames[k]
+}
+
+// weatherError describes a failure of a weather request in a way which allows to explain it to the user
+type weatherError struct {
+	kind weatherErrorKind
+	err  error
+}
+
+func newWeatherError(kind weatherErrorKind, err error) error {
+	return &weatherError{kind: kind, err: err}
+}
+
+func (e *weatherError) Error() string {
+	return e.kind.String() + ": " + e.err.Error()
+}
+
+func (e *weatherError) Unwrap() error {
+	return e.err
+}
+
+// weatherErrorKindOf returns the kind of a weather error; errors of other types are treated as malformed responses
+func weatherErrorKindOf(err error) weatherErrorKind {
+	var werr *weatherError
+	if errors.As(err, &werr) {
+		return werr.kind
+	}
+	return weatherErrMalformed
+}
+
+// weatherErrorReply returns a text for the user explaining what went wrong
+func weatherErrorReply(err error) string {
+	return weatherErrorReplies[weatherErrorKindOf(err)]
+}
+
+func logWeatherError(err error, fields log.Fields) {
+	fields["kind"] = weatherErrorKindOf(err).String()
+	fields["error"] = err
+	log.WithFields(fields).Error("weather request failed")
+}
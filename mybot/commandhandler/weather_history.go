@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	weatherHistoryDepth       = 8 * 24 * time.Hour // a week for stats plus a spare day
+	weatherHistoryMaxLength   = 8 * 24 * 6         // not more than one observation every 10 minutes
+	weatherHistoryMinInterval = 10 * time.Minute
+	weatherHistoryTolerance   = 90 * time.Minute // how far an observation may be from the requested time
+)
+
+// weatherObservation is a single stored measurement; always in metric units regardless of chat preferences
+type weatherObservation struct {
+	T        int64   `json:"t"`
+	Temp     float64 `json:"temp"` // ℃
+	Humidity int     `json:"humidity"`
+	Wind     float64 `json:"wind"` // m/s
+}
+
+func (o weatherObservation) time() time.Time {
+	return time.Unix(o.T, 0)
+}
+
+// WeatherHistory keeps bounded history of observations per city in Redis sorted sets scored by time.
+// nil history is valid: nothing is recorded and no comparisons are made
+type WeatherHistory struct {
+	conn *redis.Client
+}
+
+func NewWeatherHistory(pool tgbotbase.RedisPool) *WeatherHistory {
+	return &WeatherHistory{conn: pool.GetConnByName("openweathermap")}
+}
+
+func weatherHistoryKey(cityID int64) string {
+	return fmt.Sprintf("openweathermap:history:%d", cityID)
+}
+
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+const milesPerHourToMetersPerSecond = 0.44704
+
+// record stores current weather unless another observation has been stored recently
+func (h *WeatherHistory) record(cityID int64, data *weatherData, opts weatherOptions, now time.Time) {
+	if h == nil {
+		return
+	}
+	key := weatherHistoryKey(cityID)
+	last, err := h.conn.ZRevRangeWithScores(key, 0, 0).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("could not get last weather observation")
+		return
+	}
+	if len(last) > 0 && now.Sub(time.Unix(int64(last[0].Score), 0)) < weatherHistoryMinInterval {
+		return
+	}
+
+	obs := weatherObservation{
+		T:        now.Unix(),
+		Temp:     data.Main.Temp,
+		Humidity: data.Main.Humidity,
+		Wind:     float64(data.Wind.Speed)}
+	if opts.Units == weatherUnitsImperial {
+		obs.Temp = fahrenheitToCelsius(obs.Temp)
+		obs.Wind *= milesPerHourToMetersPerSecond
+	}
+	member, _ := json.Marshal(obs)
+
+	pipe := h.conn.TxPipeline()
+	pipe.ZAdd(key, redis.Z{Score: float64(obs.T), Member: string(member)})
+	pipe.ZRemRangeByScore(key, "-inf", strconv.FormatInt(now.Add(-weatherHistoryDepth).Unix(), 10))
+	pipe.ZRemRangeByRank(key, 0, -weatherHistoryMaxLength-1)
+	if _, err := pipe.Exec(); err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("could not store weather observation")
+	}
+}
+
+// load returns observations between 'from' and 'to' ordered by time
+func (h *WeatherHistory) load(cityID int64, from, to time.Time) []weatherObservation {
+	key := weatherHistoryKey(cityID)
+	members, err := h.conn.ZRangeByScore(key, redis.ZRangeBy{
+		Min: strconv.FormatInt(from.Unix(), 10),
+		Max: strconv.FormatInt(to.Unix(), 10)}).Result()
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err}).Error("could not load weather observations")
+		return nil
+	}
+
+	result := make([]weatherObservation, 0, len(members))
+	for _, m := range members {
+		obs := weatherObservation{}
+		if err := json.Unmarshal([]byte(m), &obs); err != nil {
+			log.WithFields(log.Fields{"key": key, "member": m, "error": err}).Error("could not parse weather observation")
+			continue
+		}
+		result = append(result, obs)
+	}
+	return result
+}
+
+// closest returns the observation nearest to t within the tolerance, nil if there is none
+func (h *WeatherHistory) closest(cityID int64, t time.Time) *weatherObservation {
+	var best *weatherObservation
+	for _, obs := range h.load(cityID, t.Add(-weatherHistoryTolerance), t.Add(weatherHistoryTolerance)) {
+		obs := obs
+		if best == nil || math.Abs(obs.time().Sub(t).Seconds()) < math.Abs(best.time().Sub(t).Seconds()) {
+			best = &obs
+		}
+	}
+	return best
+}
+
+// compareWithYesterday returns a line like 'Теплее, чем вчера в это время, на 5°'; empty if there is nothing to compare with
+func (h *WeatherHistory) compareWithYesterday(cityID int64, data *weatherData, opts weatherOptions, now time.Time) string {
+	past := h.closest(cityID, now.Add(-24*time.Hour))
+	if past == nil {
+		return ""
+	}
+
+	diff := data.Main.Temp - past.Temp
+	if opts.Units == weatherUnitsImperial {
+		diff = data.Main.Temp - celsiusToFahrenheit(past.Temp)
+	}
+	switch {
+	case math.Abs(diff) < 1:
+		return "Примерно как вчера в это время"
+	case diff > 0:
+		return fmt.Sprintf("Теплее, чем вчера в это время, на %.0f°", diff)
+	}
+	return fmt.Sprintf("Холоднее, чем вчера в это время, на %.0f°", -diff)
+}
+
+type weatherStats struct {
+	min, max, sum float64
+	count         int
+}
+
+func (s *weatherStats) add(temp float64) {
+	if s.count == 0 || temp < s.min {
+		s.min = temp
+	}
+	if s.count == 0 || temp > s.max {
+		s.max = temp
+	}
+	s.sum += temp
+	s.count++
+}
+
+func (s *weatherStats) format(opts weatherOptions) string {
+	convert := func(c float64) float64 {
+		if opts.Units == weatherUnitsImperial {
+			return celsiusToFahrenheit(c)
+		}
+		return c
+	}
+	return fmt.Sprintf("мин %.1f%s, макс %.1f%s, средняя %.1f%s",
+		convert(s.min), opts.tempUnit(),
+		convert(s.max), opts.tempUnit(),
+		convert(s.sum/float64(s.count)), opts.tempUnit())
+}
+
+// weeklyStats returns per-day and total temperature stats for the last 7 days
+func (h *WeatherHistory) weeklyStats(cityID int64, cityName string, opts weatherOptions, now time.Time) string {
+	if h == nil {
+		return "История погоды не ведётся"
+	}
+	observations := h.load(cityID, now.Add(-7*24*time.Hour), now)
+	if len(observations) == 0 {
+		return fmt.Sprintf("Я ещё не собрал погоду для города %s, спроси попозже", cityName)
+	}
+
+	total := weatherStats{}
+	days := make([]string, 0, 8)
+	daily := make(map[string]*weatherStats, 8)
+	for _, obs := range observations {
+		day := obs.time().Format(timeFormat_Out_Date)
+		if _, found := daily[day]; !found {
+			days = append(days, day)
+			daily[day] = &weatherStats{}
+		}
+		daily[day].add(obs.Temp)
+		total.add(obs.Temp)
+	}
+
+	lines := make([]string, 0, len(days)+2)
+	lines = append(lines, fmt.Sprintf("Погода в %s за неделю:", cityName))
+	for _, day := range days {
+		lines = append(lines, fmt.Sprintf("%s: %s", day, daily[day].format(opts)))
+	}
+	lines = append(lines, fmt.Sprintf("Итого: %s", total.format(opts)))
+	return strings.Join(lines, "\n")
+}
+
+// weatherHistoryJob periodically records current weather in cities of subscribed chats; it is off unless configured,
+// as every run costs an API call per city
+type weatherHistoryJob struct {
+	props    tgbotbase.PropertyStorage
+	cities   CityIndex
+	history  *WeatherHistory
+	token    string
+	sampling time.Duration
+}
+
+var _ tgbotbase.CronJob = &weatherHistoryJob{}
+
+func (job *weatherHistoryJob) Do(scheduledWhen time.Time, cron tgbotbase.Cron) {
+	defer cron.AddJob(scheduledWhen.Add(job.sampling), job)
+
+	props, _ := job.props.GetEveryHavingProperty("weatherTime")
+	seen := make(map[int64]bool, len(props))
+	for _, prop := range props {
+		if prop.Value == "" {
+			continue
+		}
+		cityID, err := getCityIDFromProperty(job.props, job.cities, prop.User, prop.Chat)
+		if err != nil || seen[cityID] {
+			continue
+		}
+		seen[cityID] = true
+
+		data, err := requestCurrentWeather(job.token, cityID, defaultWeatherOptions)
+		if err != nil {
+			logWeatherError(err, log.Fields{"job": "weather history", "city": cityID})
+			continue
+		}
+		job.history.record(cityID, data, defaultWeatherOptions, time.Now())
+	}
+}
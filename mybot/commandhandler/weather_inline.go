@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+var reInlineWeather = regexp.MustCompile("^(погода|weather)\\s*")
+
+const inlineWeatherCacheTime = 300 // seconds
+
+type weatherInlineHandler struct {
+	tgbotbase.BaseHandler
+	token      string
+	cities     CityIndex
+	properties tgbotbase.PropertyStorage
+	history    *WeatherHistory
+}
+
+var _ tgbotbase.InlineQueryHandler = &weatherInlineHandler{}
+
+func NewWeatherInlineHandler(token string, cities CityIndex, properties tgbotbase.PropertyStorage, history *WeatherHistory) tgbotbase.InlineQueryHandler {
+	return &weatherInlineHandler{
+		token:      token,
+		cities:     cities,
+		properties: properties,
+		history:    history}
+}
+
+func (h *weatherInlineHandler) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- tgbotbase.ServiceMsg) {
+	h.OutMsgCh = outMsgCh
+}
+
+func (h *weatherInlineHandler) Name() string {
+	return "inline weather"
+}
+
+// HandleInline answers with current weather, today's and tomorrow's forecasts for the city from the query or from user's 'city' property
+func (h *weatherInlineHandler) HandleInline(query tgbotapi.InlineQuery) *tgbotapi.InlineConfig {
+	text := strings.TrimSpace(query.Query)
+	lowered := strings.ToLower(text)
+	if lowered != "" && !reInlineWeather.MatchString(lowered) {
+		return nil
+	}
+	city := strings.TrimSpace(reInlineWeather.ReplaceAllString(text, ""))
+	city = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(city, "в "), "in "))
+
+	user := tgbotbase.UserID(query.From.ID)
+	var cityID int64
+	var err error
+	if city == "" {
+		cityID, err = getCityIDFromProperty(h.properties, h.cities, user, tgbotbase.ChatID(user))
+	} else {
+		cityID, err = getCityIDByName(h.cities, city)
+	}
+	if err != nil {
+		logWeatherError(err, log.Fields{"inline": query.Query, "user": user})
+		return h.answer(query, []interface{}{
+			tgbotapi.NewInlineQueryResultArticle(query.ID+"-error", weatherErrorReply(err), weatherErrorReply(err))})
+	}
+
+	opts := loadWeatherOptions(h.properties, user, tgbotbase.ChatID(user))
+	now := time.Now()
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.Local)
+
+	type inlineResult struct {
+		title string
+		text  string
+		err   error
+	}
+	results := []inlineResult{{title: "Погода сейчас"}, {title: "Прогноз на сегодня"}, {title: "Прогноз на завтра"}}
+	var wg sync.WaitGroup
+	wg.Add(len(results))
+	go func() {
+		defer wg.Done()
+		results[0].text, results[0].err = getCurrentWeather(h.token, cityID, opts, h.history)
+	}()
+	go func() {
+		defer wg.Done()
+		results[1].text, results[1].err = getForecast(h.token, cityID, now, opts)
+	}()
+	go func() {
+		defer wg.Done()
+		results[2].text, results[2].err = getForecast(h.token, cityID, tomorrow, opts)
+	}()
+	wg.Wait()
+
+	articles := make([]interface{}, 0, len(results))
+	for i, res := range results {
+		if res.err != nil {
+			logWeatherError(res.err, log.Fields{"inline": query.Query, "user": user, "city": cityID})
+			continue
+		}
+		article := tgbotapi.NewInlineQueryResultArticle(fmt.Sprintf("%d-%d-%d", cityID, now.Unix()/inlineWeatherCacheTime, i), res.title, res.text)
+		article.Description = strings.SplitN(res.text, "\n", 2)[0]
+		articles = append(articles, article)
+	}
+	if len(articles) == 0 {
+		reply := weatherErrorReply(results[0].err)
+		articles = append(articles, tgbotapi.NewInlineQueryResultArticle(query.ID+"-error", reply, reply))
+	}
+	return h.answer(query, articles)
+}
+
+func (h *weatherInlineHandler) answer(query tgbotapi.InlineQuery, results []interface{}) *tgbotapi.InlineConfig {
+	return &tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     inlineWeatherCacheTime,
+		IsPersonal:    true}
+}
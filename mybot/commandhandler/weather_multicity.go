@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+// maxWeatherCities limits cities in one request, as every city costs one or two API calls
+const maxWeatherCities = 5
+
+// cityWeather is a result of weather lookup for one of several requested cities
+type cityWeather struct {
+	city     string
+	current  *weatherData
+	forecast string
+	err      error
+}
+
+// getWeatherForCities looks up cities and requests their weather concurrently; order of results matches order of cities.
+// Current weather is requested if date is nil, otherwise forecast for the date
+func (h *weatherHandler) getWeatherForCities(cities []string, date *time.Time, opts weatherOptions) []cityWeather {
+	results := make([]cityWeather, len(cities))
+	var wg sync.WaitGroup
+	for i, city := range cities {
+		wg.Add(1)
+		go func(i int, city string) {
+			defer wg.Done()
+			res := &results[i]
+			res.city = city
+
+			cityID, err := getCityIDByName(h.cities, city)
+			if err == nil {
+				if date == nil {
+					res.current, err = requestCurrentWeather(h.token, cityID, opts)
+					if err == nil {
+						h.history.record(cityID, res.current, opts, time.Now())
+					}
+				} else {
+					res.forecast, err = getForecast(h.token, cityID, *date, opts)
+				}
+			}
+			if err != nil {
+				logWeatherError(err, log.Fields{"city": city})
+				res.err = err
+			}
+		}(i, city)
+	}
+	wg.Wait()
+	return results
+}
+
+func escapeMarkdownCode(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return strings.Replace(s, "`", "\\`", -1)
+}
+
+// formatWeatherTable renders current weather in several cities as a fixed-width table
+func formatWeatherTable(results []cityWeather, opts weatherOptions) string {
+	nameWidth := 0
+	for _, res := range results {
+		name := res.city
+		if res.current != nil && res.current.Name != "" {
+			name = res.current.Name
+		}
+		if l := len([]rune(name)); l > nameWidth {
+			nameWidth = l
+		}
+	}
+
+	lines := make([]string, 0, len(results))
+	for _, res := range results {
+		if res.err != nil || res.current == nil {
+			lines = append(lines, fmt.Sprintf("%-*s  %s", nameWidth, res.city, shortWeatherErrorReply(res.err)))
+			continue
+		}
+		data := res.current
+		name := res.city
+		if data.Name != "" {
+			name = data.Name
+		}
+		line := fmt.Sprintf("%-*s %6.1f%s %3.0f %s  %s", nameWidth, name,
+			data.Main.Temp, opts.tempUnit(),
+			data.Wind.Speed, opts.speedUnit(),
+			data.description())
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+
+	return fmt.Sprintf("Сейчас:\n```\n%s\n```", escapeMarkdownCode(strings.Join(lines, "\n")))
+}
+
+func (h *weatherHandler) multiCityReply(msg tgbotapi.Message, cities []string, date *time.Time, opts weatherOptions) tgbotapi.Chattable {
+	var reply tgbotapi.MessageConfig
+	if len(cities) > maxWeatherCities {
+		log.WithFields(log.Fields{"cities": len(cities), "chat": msg.Chat.ID}).Warn("Too many cities requested")
+		reply = tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Слишком много городов, можно не больше %d за раз", maxWeatherCities))
+		reply.BaseChat.ReplyToMessageID = msg.MessageID
+		return reply
+	}
+
+	results := h.getWeatherForCities(cities, date, opts)
+	if date == nil {
+		reply = tgbotapi.NewMessage(msg.Chat.ID, formatWeatherTable(results, opts))
+		reply.ParseMode = "MarkdownV2"
+	} else {
+		texts := make([]string, 0, len(results))
+		for _, res := range results {
+			if res.err != nil {
+				texts = append(texts, fmt.Sprintf("%s: %s", res.city, shortWeatherErrorReply(res.err)))
+				continue
+			}
+			texts = append(texts, res.forecast)
+		}
+		reply = tgbotapi.NewMessage(msg.Chat.ID, strings.Join(texts, "\n"))
+	}
+	reply.BaseChat.ReplyToMessageID = msg.MessageID
+	return reply
+}
+
+// shortWeatherErrorReply explains the failure for a single city in a list of several ones
+func shortWeatherErrorReply(err error) string {
+	if weatherErrorKindOf(err) == weatherErrUnknownCity {
+		return "город не найден"
+	}
+	return "нет данных"
+}
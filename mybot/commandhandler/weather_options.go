@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	weatherUnitsMetric   = "metric"
+	weatherUnitsImperial = "imperial"
+
+	weatherDetailShort = "short"
+	weatherDetailFull  = "full"
+
+	weatherDefaultDescriptionLang = "ru"
+
+	// weatherDescriptionLangProperty sets only the language of descriptions coming from the API ("облачно", "light rain"),
+	// replies of the bot itself are always in Russian
+	weatherDescriptionLangProperty = "weatherDescriptionLang"
+	// weatherLegacyLangProperty is the former name which is still read if the new one is not set
+	weatherLegacyLangProperty = "weatherLang"
+
+	hPaToMmHg = 0.750062
+)
+
+// weatherOptions describe how weather should be requested and presented to a particular user/chat
+type weatherOptions struct {
+	Units           string // 'metric' or 'imperial', passed to the API as is
+	DescriptionLang string // language of weather descriptions only, passed to the API as is
+	Detailed        bool
+}
+
+var defaultWeatherOptions = weatherOptions{
+	Units:           weatherUnitsMetric,
+	DescriptionLang: weatherDefaultDescriptionLang,
+	Detailed:        false}
+
+// loadWeatherOptions reads 'weatherUnits', 'weatherDescriptionLang' (or legacy 'weatherLang') and 'weatherDetail' properties; invalid or absent values fall back to defaults
+func loadWeatherOptions(props tgbotbase.PropertyStorage, user tgbotbase.UserID, chat tgbotbase.ChatID) weatherOptions {
+	opts := defaultWeatherOptions
+
+	units, _ := props.GetProperty("weatherUnits", user, chat)
+	switch units {
+	case "":
+	case weatherUnitsMetric, weatherUnitsImperial:
+		opts.Units = units
+	default:
+		log.Printf("Unknown weather units '%s' for user %d chat %d, using %s", units, user, chat, opts.Units)
+	}
+
+	lang, _ := props.GetProperty(weatherDescriptionLangProperty, user, chat)
+	if lang == "" {
+		lang, _ = props.GetProperty(weatherLegacyLangProperty, user, chat)
+	}
+	if lang != "" {
+		opts.DescriptionLang = lang
+	}
+
+	detail, _ := props.GetProperty("weatherDetail", user, chat)
+	switch detail {
+	case "", weatherDetailShort:
+	case weatherDetailFull:
+		opts.Detailed = true
+	default:
+		log.Printf("Unknown weather detail level '%s' for user %d chat %d, using short", detail, user, chat)
+	}
+
+	return opts
+}
+
+func (opts weatherOptions) tempUnit() string {
+	if opts.Units == weatherUnitsImperial {
+		return "\u2109"
+	}
+	return "\u2103"
+}
+
+func (opts weatherOptions) speedUnit() string {
+	if opts.Units == weatherUnitsImperial {
+		return "миль/ч"
+	}
+	return "м/с"
+}
+
+var compassPoints = []string{"С", "ССВ", "СВ", "ВСВ", "В", "ВЮВ", "ЮВ", "ЮЮВ", "Ю", "ЮЮЗ", "ЮЗ", "ЗЮЗ", "З", "ЗСЗ", "СЗ", "ССЗ"}
+
+// compassPoint converts meteorological wind direction in degrees into one of 16 compass points
+func compassPoint(deg float64) string {
+	sector := 360.0 / float64(len(compassPoints))
+	idx := int(math.Floor(math.Mod(deg+sector/2, 360) / sector))
+	return compassPoints[idx%len(compassPoints)]
+}
+
+func formatVisibility(meters int) string {
+	if meters >= 1000 {
+		return fmt.Sprintf("%.1f км", float64(meters)/1000)
+	}
+	return fmt.Sprintf("%d м", meters)
+}
+
+// cityLocalTime converts unix time into the time of the city using its offset from UTC in seconds
+func cityLocalTime(unix int64, offset int) time.Time {
+	return time.Unix(unix, 0).In(time.FixedZone("", offset))
+}
+
+// WeatherProperties declares properties used by weather requests
+func WeatherProperties(cities CityIndex) []PropertyDef {
+	return []PropertyDef{
+		cityProperty("city", "город для погоды по умолчанию", cities),
+		enumProperty("weatherUnits", weatherUnitsMetric, "единицы измерения", weatherUnitsMetric, weatherUnitsImperial),
+		langProperty(weatherDescriptionLangProperty, weatherDefaultDescriptionLang, "язык описаний погоды от сервиса (\"облачно\", \"light rain\"), остальной текст ответов всегда на русском"),
+		enumProperty("weatherDetail", weatherDetailShort, "подробность прогноза", weatherDetailShort, weatherDetailFull)}
+}
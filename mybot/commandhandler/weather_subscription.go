@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/admirallarimda/tgbotbase"
+	log "github.com/sirupsen/logrus"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+const weatherSubscriptionUsage = `Управление утренним прогнозом:
+/weather subscribe 07:30 Москва - присылать прогноз каждый день в 07:30
+/weather unsubscribe - больше не присылать
+/weather status - текущие настройки
+/weather stats [город] - погода за неделю`
+
+var reClockTime = regexp.MustCompile("^(\\d{1,2}):(\\d{2})$")
+
+// parseDailyTime converts either '07:30' or Go duration format ('7h30m') into duration from midnight
+func parseDailyTime(s string) (time.Duration, error) {
+	if reClockTime.MatchString(s) {
+		matches := reClockTime.FindStringSubmatch(s)
+		hours, _ := strconv.Atoi(matches[1])
+		minutes, _ := strconv.Atoi(matches[2])
+		if hours > 23 || minutes > 59 {
+			return 0, fmt.Errorf("time '%s' is out of range", s)
+		}
+		return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+	}
+
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if dur < 0 || dur >= 24*time.Hour {
+		return 0, fmt.Errorf("time '%s' is out of range", s)
+	}
+	return dur, nil
+}
+
+// formatDailyTime converts duration from midnight into the format which is stored in properties
+func formatDailyTime(dur time.Duration) string {
+	return fmt.Sprintf("%dh%dm", int(dur.Hours()), int(dur.Minutes())%60)
+}
+
+// handleSubcommand processes '/weather subscribe|unsubscribe|status|stats'. Returns false if the command is not one of them
+func (h *weatherHandler) handleSubcommand(msg tgbotapi.Message) bool {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		return false
+	}
+
+	chat := tgbotbase.ChatID(msg.Chat.ID)
+	user := tgbotbase.UserID(0)
+	if msg.Chat.IsPrivate() {
+		user = tgbotbase.UserID(msg.From.ID)
+	}
+
+	var replyText string
+	switch strings.ToLower(args[0]) {
+	case "subscribe", "unsubscribe":
+		if !h.permissions.canChangeChatIn(msg) {
+			log.Printf("User %d is not allowed to change weather subscription in chat %d", msg.From.ID, chat)
+			replyText = chatSettingsDenied
+			break
+		}
+		if strings.ToLower(args[0]) == "subscribe" {
+			replyText = h.subscribe(msg.From, user, chat, args[1:])
+		} else {
+			replyText = h.unsubscribe(msg.From, chat)
+		}
+	case "status":
+		replyText = h.subscriptionStatus(chat)
+	case "stats":
+		replyText = h.stats(msg, args[1:])
+	case "help":
+		replyText = weatherSubscriptionUsage
+	default:
+		return false
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, replyText)
+	reply.BaseChat.ReplyToMessageID = msg.MessageID
+	h.OutMsgCh <- reply
+	return true
+}
+
+func (h *weatherHandler) subscribe(actor *tgbotapi.User, user tgbotbase.UserID, chat tgbotbase.ChatID, args []string) string {
+	if len(args) == 0 {
+		return weatherSubscriptionUsage
+	}
+
+	dur, err := parseDailyTime(args[0])
+	if err != nil {
+		log.Printf("Could not parse subscription time '%s' due to error: %s", args[0], err)
+		return fmt.Sprintf("Не понял время '%s', нужно что-то вроде 07:30", args[0])
+	}
+
+	city := strings.Join(args[1:], " ")
+	if city == "" {
+		city, _ = h.properties.GetProperty("city", user, chat)
+		if city == "" {
+			return "Не знаю, для какого города присылать прогноз. Укажи его после времени: /weather subscribe 07:30 Москва"
+		}
+	}
+	if _, err := getCityIDByName(h.cities, city); err != nil {
+		log.Printf("Could not validate city '%s' for subscription due to error: %s", city, err)
+		if weatherErrorKindOf(err) != weatherErrUnknownCity {
+			return weatherErrorReply(err)
+		}
+		return fmt.Sprintf("Не знаю города '%s' :(", city)
+	}
+
+	newTime := formatDailyTime(dur)
+	oldCity := exactPropertyValue(h.properties, "city", 0, chat)
+	if err := h.properties.SetPropertyForChat("city", chat, city); err != nil {
+		log.Printf("Could not set city for chat %d due to error: %s", chat, err)
+		return "Не смог сохранить подписку :("
+	}
+	oldTime := exactPropertyValue(h.properties, "weatherTime", 0, chat)
+	if err := h.properties.SetPropertyForChat("weatherTime", chat, newTime); err != nil {
+		log.Printf("Could not set weather time for chat %d due to error: %s", chat, err)
+		h.restoreChatProperty("city", chat, oldCity)
+		return "Не смог сохранить подписку :("
+	}
+	if oldCity != city {
+		h.audit.Record(chat, newSettingsChange(actor, "city", 0, oldCity, city, "weather subscribe"))
+	}
+	if oldTime != newTime {
+		h.audit.Record(chat, newSettingsChange(actor, "weatherTime", 0, oldTime, newTime, "weather subscribe"))
+	}
+
+	when := tgbotbase.CalcNextTimeFromMidnight(time.Now(), dur)
+	h.morning.schedule(chat, when)
+
+	return fmt.Sprintf("Подписал на прогноз для города %s, ближайший придёт %s", city,
+		inUserTimezone(h.properties, chatLevel(chat), chat, when).Format(timeFormat_Out_Confirm))
+}
+
+// restoreChatProperty rolls back a chat-level property which has been set as a part of a failed change
+func (h *weatherHandler) restoreChatProperty(name string, chat tgbotbase.ChatID, old string) {
+	var err error
+	if old == "" {
+		err = h.properties.DeletePropertyForUserInChat(name, 0, chat)
+	} else {
+		err = h.properties.SetPropertyForChat(name, chat, old)
+	}
+	if err != nil {
+		log.Printf("Could not restore %s for chat %d due to error: %s", name, chat, err)
+	}
+}
+
+func (h *weatherHandler) unsubscribe(actor *tgbotapi.User, chat tgbotbase.ChatID) string {
+	h.morning.unschedule(chat)
+	oldTime := exactPropertyValue(h.properties, "weatherTime", 0, chat)
+	if err := h.properties.DeletePropertyForUserInChat("weatherTime", 0, chat); err != nil {
+		log.Printf("Could not delete weather time for chat %d due to error: %s", chat, err)
+		return "Не смог отписаться :("
+	}
+	h.audit.Record(chat, newSettingsChange(actor, "weatherTime", 0, oldTime, "", "weather unsubscribe"))
+	return "Больше не буду присылать утренний прогноз"
+}
+
+// subscriptionStatus shows the subscription the way the morning job sees it, so only chat-level values are used
+func (h *weatherHandler) subscriptionStatus(chat tgbotbase.ChatID) string {
+	user := chatLevel(chat)
+	weatherTime, _ := h.properties.GetProperty("weatherTime", user, chat)
+	city, _ := h.properties.GetProperty("city", user, chat)
+	if weatherTime == "" {
+		return "Утренний прогноз не настроен. " + weatherSubscriptionUsage
+	}
+
+	dur, err := time.ParseDuration(weatherTime)
+	if err != nil {
+		return fmt.Sprintf("Время прогноза '%s' некорректно, переподпишись: /weather subscribe 07:30 %s", weatherTime, city)
+	}
+	when := tgbotbase.CalcNextTimeFromMidnight(time.Now(), dur)
+	return fmt.Sprintf("Присылаю прогноз для города %s, ближайший придёт %s", city,
+		inUserTimezone(h.properties, user, chat, when).Format(timeFormat_Out_Confirm))
+}
+
+func (h *weatherHandler) stats(msg tgbotapi.Message, args []string) string {
+	user := tgbotbase.UserID(msg.From.ID)
+	chat := tgbotbase.ChatID(msg.Chat.ID)
+
+	city := strings.Join(args, " ")
+	if city == "" {
+		city, _ = h.properties.GetProperty("city", user, chat)
+	}
+	cityID, err := getCityIDByName(h.cities, city)
+	if err != nil {
+		logWeatherError(err, log.Fields{"stats": city, "chat": chat})
+		return weatherErrorReply(err)
+	}
+
+	return h.history.weeklyStats(cityID, city, loadWeatherOptions(h.properties, user, chat), time.Now())
+}
@@ -1,6 +1,13 @@
 package mybot
 
 import (
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/admirallarimda/tgbotbase"
@@ -16,6 +23,10 @@ func Start(cfg_filename string) error {
 		log.Printf("My bot cannot be sarted due to error: %s", err)
 		return err
 	}
+	if err := fullcfg.Validate(); err != nil {
+		log.Printf("My bot cannot be started with invalid configuration: %s", err)
+		return err
+	}
 
 	log.Printf("Starting bot with full config: %+v", fullcfg)
 
@@ -27,18 +38,159 @@ func Start(cfg_filename string) error {
 	redispool := tgbotbase.NewRedisPool(rediscfg)
 	propstorage := tgbotbase.NewRedisPropertyStorage(redispool)
 	remindstorage := cmd.NewRedisReminderStorage(redispool)
+	jobregistry := cmd.NewRedisJobRegistry(redispool)
+	settingslog := cmd.NewRedisSettingsLog(redispool)
+
+	grace, _ := fullcfg.missedGrace()
+	shutdownTimeout, _ := fullcfg.shutdownTimeout()
+	historySampling, _ := fullcfg.historySampling()
+
+	air, err := cmd.NewAirQualityProvider(fullcfg.Weather.Air_Provider, fullcfg.Weather.Air_URL, fullcfg.Weather.Token)
+	if err != nil {
+		log.Printf("Could not create air quality provider due to error: %s", err)
+		return err
+	}
+
+	cities, err := cmd.NewCityIndex(fullcfg.Weather.Cities, fullcfg.Weather.Cities_File, fullcfg.Weather.Cities_Prefix, redispool)
+	if err != nil {
+		log.Printf("Could not create city index due to error: %s", err)
+		return err
+	}
+
+	// weather history is kept in 'openweathermap' Redis DB, which is not needed when cities are loaded from a file
+	var history *cmd.WeatherHistory
+	if !fullcfg.citiesFromFile() {
+		history = cmd.NewWeatherHistory(redispool)
+	}
 
 	cron := tgbotbase.NewCron()
 
-	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewPropertyHandler(propstorage)))
-	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewWeatherHandler(fullcfg.Weather.Token, redispool, propstorage)))
-	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewRemindHandler(cron, remindstorage, propstorage)))
-	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(cmd.NewKittiesHandler(cron, propstorage)))
-	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(cmd.NewWeatherMorningHandler(cron, propstorage, redispool, fullcfg.Weather.Token)))
-	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(cmd.NewCovid19Handler(cron, propstorage)))
-	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(cmd.NewNewsNNHandler(cron, propstorage)))
+	properties := cmd.NewPropertyRegistry()
+	properties.Register(cmd.WeatherProperties(cities)...)
+	properties.Register(cmd.WeatherMorningProperties()...)
+	properties.Register(cmd.RemindProperties()...)
+	properties.Register(cmd.KittiesProperties()...)
+	properties.Register(cmd.Covid19Properties()...)
+	properties.Register(cmd.NewsNNProperties()...)
+
+	permissions := cmd.NewPermissions(bot.API(), fullcfg.Owners.ID)
+
+	weatherMorning := cmd.NewWeatherMorningHandler(cron, propstorage, cities, jobregistry, grace, air, fullcfg.Weather.Token, history, historySampling)
+	kitties := cmd.NewKittiesHandler(cron, propstorage, jobregistry, grace)
+	covid19 := cmd.NewCovid19Handler(cron, propstorage)
+	newsNN := cmd.NewNewsNNHandler(cron, propstorage, jobregistry, grace)
+	remind := cmd.NewRemindHandler(cron, remindstorage, propstorage)
+	properties.OnChange("weatherTime", weatherMorning.PropertyChanged)
+	properties.OnChange("catTime", kitties.PropertyChanged)
+	properties.OnChange("covid19Time", covid19.PropertyChanged)
+	properties.OnChange("nnNewsTime", newsNN.PropertyChanged)
+
+	// only owners and schedules can be changed without a restart, other config fields are just validated
+	reload := func() error {
+		newcfg, err := NewConfig(cfg_filename)
+		if err != nil {
+			return err
+		}
+		if err := newcfg.Validate(); err != nil {
+			return err
+		}
+		permissions.SetOwners(newcfg.Owners.ID)
+		return properties.Resync(propstorage)
+	}
+
+	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewAdminHandler(bot, cron, propstorage, permissions, reload)))
+	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewPropertyHandler(propstorage, properties, permissions, settingslog)))
+	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewSettingsHandler(propstorage, properties, permissions, settingslog)))
+	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewSettingsTransferHandler(bot.API(), propstorage, properties, permissions, remind, jobregistry, settingslog)))
+	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(cmd.NewWeatherHandler(fullcfg.Weather.Token, cities, propstorage, weatherMorning, air, history, permissions, settingslog)))
+	bot.AddHandler(tgbotbase.NewInlineQueryDealer(cmd.NewWeatherInlineHandler(fullcfg.Weather.Token, cities, propstorage, history)))
+	bot.AddHandler(tgbotbase.NewIncomingMessageDealer(remind))
+	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(kitties))
+	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(weatherMorning))
+	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(covid19))
+	bot.AddHandler(tgbotbase.NewBackgroundMessageDealer(newsNN))
+	logStartupSummary(fullcfg, bot.HandlerNames())
+
+	// the deadline starts either on signal or when the bot has been stopped by a command;
+	// if a handler hangs and the main cycle cannot even finish, the process is killed by the deadline
+	var shutdownOnce sync.Once
+	var deadline time.Time
+	var watchdog *time.Timer
+	beginShutdown := func() {
+		shutdownOnce.Do(func() {
+			deadline = time.Now().Add(shutdownTimeout)
+			watchdog = time.AfterFunc(shutdownTimeout, func() {
+				log.Errorf("Bot has not been stopped within %s, exiting", shutdownTimeout)
+				os.Exit(1)
+			})
+		})
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.WithField("signal", sig).Info("Signal received, stopping")
+		beginShutdown()
+		bot.Stop()
+	}()
+
 	bot.Start()
+	signal.Stop(signals)
 
 	log.Print("Stopping my bot")
+	beginShutdown()
+	if !cron.Stop(time.Until(deadline)) {
+		// a job still running may reply at any moment, so outgoing messages cannot be flushed safely
+		log.Warn("Scheduled jobs have not finished in time, queued replies are dropped")
+	} else if err := bot.Shutdown(time.Until(deadline)); err != nil {
+		log.WithError(err).Warn("Bot has not been shut down gracefully")
+	}
+	if err := redispool.Close(); err != nil {
+		log.WithError(err).Warn("Could not close Redis connections")
+	}
+	watchdog.Stop()
+	log.Print("My bot has been stopped")
 	return nil
 }
+
+// logStartupSummary shows what the bot is going to do without revealing any credentials
+func logStartupSummary(cfg Config, handlers []string) {
+	proxy := "none"
+	if cfg.Proxy_SOCKS5.Server != "" {
+		proxy = cfg.Proxy_SOCKS5.Server
+	}
+	air := cfg.Weather.Air_Provider
+	if air == "" {
+		air = "openweathermap"
+	}
+	cities := cfg.Weather.Cities
+	if cities == "" {
+		cities = "redis"
+	}
+	log.WithFields(log.Fields{
+		"telegram":       enabledIf(!cfg.TGBot.SkipConnect),
+		"proxy":          proxy,
+		"redis":          cfg.Redis.Server,
+		"weatherKey":     configuredIf(cfg.Weather.Token != ""),
+		"airQuality":     air,
+		"cities":         cities,
+		"owners":         len(cfg.Owners.ID),
+		"missedGrace":    cfg.Scheduler.Missed_Grace,
+		"weatherHistory": cfg.Weather.History_Sampling,
+		"handlers":       strings.Join(handlers, ", "),
+	}).Info("Bot is configured")
+}
+
+func enabledIf(on bool) string {
+	if on {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func configuredIf(set bool) string {
+	if set {
+		return "set"
+	}
+	return "not set"
+}
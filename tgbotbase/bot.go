@@ -0,0 +1,306 @@
+package tgbotbase
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+	tgbotapi "gopkg.in/telegram-bot-api.v4"
+)
+
+type Bot struct {
+	dealers []MessageDealer
+	cfg     Config
+
+	bot         *tgbotapi.BotAPI
+	botChannels struct {
+		in_msg_chan  tgbotapi.UpdatesChannel
+		out_msg_chan chan tgbotapi.Chattable
+		service_chan chan ServiceMsg
+	}
+
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	inFlight    sync.WaitGroup // inline and callback queries being answered
+	repliesStop chan struct{}
+	repliesDone chan struct{}
+}
+
+func NewBot(cfg Config) *Bot {
+	b := &Bot{dealers: make([]MessageDealer, 0),
+		cfg: cfg}
+
+	botToken := cfg.TGBot.Token
+	log.Printf("Setting up a bot with token: %s", MaskSecret(botToken))
+
+	b.botChannels.out_msg_chan = make(chan tgbotapi.Chattable, 0)
+	b.botChannels.service_chan = make(chan ServiceMsg, 0)
+	b.stopCh = make(chan struct{})
+	b.repliesStop = make(chan struct{})
+	b.repliesDone = make(chan struct{})
+
+	if cfg.TGBot.SkipConnect {
+		return b
+	}
+
+	// tgbotapi logs request URLs on polling errors, they contain the token
+	tgbotapi.SetLogger(&redactingLogger{secrets: []string{botToken, cfg.Proxy_SOCKS5.Pass}})
+
+	// connecting to Telegram
+	if cfg.Proxy_SOCKS5.Server != "" {
+		log.Printf("Proxy is set, connecting to '%s' with credentials '%s':'%s'", cfg.Proxy_SOCKS5.Server, cfg.Proxy_SOCKS5.User, MaskSecret(cfg.Proxy_SOCKS5.Pass))
+		auth := proxy.Auth{User: cfg.Proxy_SOCKS5.User,
+			Password: cfg.Proxy_SOCKS5.Pass}
+		dialer, err := proxy.SOCKS5("tcp", cfg.Proxy_SOCKS5.Server, &auth, proxy.Direct)
+		if err != nil {
+			log.Panicf("Could get proxy dialer, error: %s", RedactSecrets(err.Error(), cfg.Proxy_SOCKS5.Pass))
+		}
+		httpTransport := &http.Transport{}
+		httpTransport.Dial = dialer.Dial
+		httpClient := &http.Client{Transport: httpTransport}
+		b.bot, err = tgbotapi.NewBotAPIWithClient(botToken, httpClient)
+		if err != nil {
+			log.Panicf("Could not connect via proxy, error: %s", RedactSecrets(err.Error(), botToken, cfg.Proxy_SOCKS5.Pass))
+		}
+	} else {
+		log.Printf("No proxy is set, going without any proxy")
+		var err error
+		b.bot, err = tgbotapi.NewBotAPI(botToken)
+		if err != nil {
+			log.Panicf("Could not connect directly, error: %s", RedactSecrets(err.Error(), botToken))
+		}
+	}
+
+	log.Printf("Authorized on account %s", b.bot.Self.UserName)
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+
+	updates, err := b.bot.GetUpdatesChan(u)
+	if err != nil {
+		log.Panic(err)
+	}
+	b.botChannels.in_msg_chan = updates
+
+	return b
+}
+
+func (b *Bot) AddHandler(d MessageDealer) {
+	log.Printf("Preparing '%s' handler", d.name())
+	d.init(b.botChannels.out_msg_chan, b.botChannels.service_chan)
+	b.dealers = append(b.dealers, d)
+}
+
+func (b *Bot) Start() {
+	log.Printf("Starting bot")
+	for _, d := range b.dealers {
+		log.Printf("Starting handler '%s'", d.name())
+		d.run()
+	}
+
+	go b.serveReplies()
+	isRunning := true
+	for isRunning {
+		select {
+		case update := <-b.botChannels.in_msg_chan:
+			log.Printf("Received an update from tgbotapi")
+			if update.InlineQuery != nil {
+				b.inFlight.Add(1)
+				go b.answerInline(*update.InlineQuery)
+				continue
+			}
+			if update.CallbackQuery != nil {
+				b.inFlight.Add(1)
+				go b.answerCallback(*update.CallbackQuery)
+				continue
+			}
+			if update.Message == nil {
+				log.Print("Message: empty. Skipping")
+				continue
+			}
+			if b.cfg.TGBot.Verbose {
+				dumpMessage(update)
+			}
+			for _, d := range b.dealers {
+				d.accept(*update.Message)
+			}
+		case srvMsg := <-b.botChannels.service_chan:
+			log.Printf("Received service message: %+v", srvMsg)
+			if srvMsg.stopBot {
+				isRunning = false
+			}
+		case <-b.stopCh:
+			log.Printf("Stop has been requested")
+			isRunning = false
+		}
+	}
+	if b.bot != nil {
+		b.bot.StopReceivingUpdates()
+	}
+	if lost := len(b.botChannels.in_msg_chan); lost > 0 {
+		// Telegram considers them delivered, so they will not come again after restart
+		log.Printf("%d received updates have not been processed and are lost", lost)
+	}
+
+	log.Print("Main cycle has been aborted")
+}
+
+// Shutdown should be called after Start has returned: it lets handlers finish messages they are processing
+// and drains background handlers implementing DrainableBackgroundHandler, then sends all queued replies. Error is returned if it has not been done within timeout
+func (b *Bot) Shutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for _, d := range b.dealers {
+		drainable, ok := d.(drainer)
+		if !ok {
+			continue
+		}
+		if !waitUntil(drainable.drain(), deadline) {
+			return fmt.Errorf("handler '%s' has not finished in time", d.name())
+		}
+	}
+
+	queries := make(chan struct{})
+	go func() {
+		b.inFlight.Wait()
+		close(queries)
+	}()
+	if !waitUntil(queries, deadline) {
+		return fmt.Errorf("inline and callback queries have not been answered in time")
+	}
+
+	// every send of drained handlers has been received by now, so serving can finish after the reply being sent.
+	// The channel itself is not closed: a handler which still sends from its own goroutine blocks instead of panicking
+	close(b.repliesStop)
+	if !waitUntil(b.repliesDone, deadline) {
+		return fmt.Errorf("replies have not been sent in time")
+	}
+	log.Print("Bot has been shut down")
+	return nil
+}
+
+func waitUntil(done <-chan struct{}, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// answerInline passes the query to inline dealers one by one; the first answer is sent as only one answer per query is allowed
+func (b *Bot) answerInline(query tgbotapi.InlineQuery) {
+	defer b.inFlight.Done()
+	for _, d := range b.dealers {
+		inline, ok := d.(inlineDealer)
+		if !ok {
+			continue
+		}
+		answer := inline.acceptInline(query)
+		if answer == nil {
+			continue
+		}
+		if _, err := b.bot.AnswerInlineQuery(*answer); err != nil {
+			log.Printf("Could not answer inline query %+v due to error: %s", query, b.redact(err))
+		}
+		return
+	}
+	log.Printf("No answer for inline query '%s'", query.Query)
+}
+
+// answerCallback passes the button press to the first handler accepting it; the query is always answered so that the client stops waiting
+func (b *Bot) answerCallback(query tgbotapi.CallbackQuery) {
+	defer b.inFlight.Done()
+	answer := tgbotapi.NewCallback(query.ID, "")
+	for _, d := range b.dealers {
+		callback, ok := d.(callbackDealer)
+		if !ok {
+			continue
+		}
+		accepted, handlerAnswer := callback.acceptCallback(query)
+		if !accepted {
+			continue
+		}
+		if handlerAnswer != nil {
+			answer = *handlerAnswer
+		}
+		break
+	}
+	if _, err := b.bot.AnswerCallbackQuery(answer); err != nil {
+		log.Printf("Could not answer callback query '%s' due to error: %s", query.Data, b.redact(err))
+	}
+}
+
+// Stop asks the main cycle to finish; it is the same as a stop service message sent by a handler, but can be called several times from anywhere
+func (b *Bot) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopCh)
+	})
+}
+
+// HandlerNames lists names of all added handlers in order of addition
+func (b *Bot) HandlerNames() []string {
+	names := make([]string, 0, len(b.dealers))
+	for _, d := range b.dealers {
+		names = append(names, d.name())
+	}
+	return names
+}
+
+// API gives access to Telegram API for requests which are not covered by handlers (e.g. chat members); nil if connection is skipped
+func (b *Bot) API() *tgbotapi.BotAPI {
+	return b.bot
+}
+
+func (b *Bot) Send(msg tgbotapi.Chattable) {
+	b.botChannels.out_msg_chan <- msg
+}
+
+func (b *Bot) serveReplies() {
+	defer close(b.repliesDone)
+	log.Print("Started serving replies")
+	for {
+		select {
+		case msg := <-b.botChannels.out_msg_chan:
+			log.Printf("Will send a reply")
+			_, err := b.bot.Send(msg)
+			if err != nil {
+				log.Printf("Could not sent reply %+v due to error: %s", msg, b.redact(err))
+			}
+		case <-b.repliesStop:
+			log.Print("Finished serving replies")
+			return
+		}
+	}
+}
+
+func dumpMessage(update tgbotapi.Update) {
+	log.Printf("Message from: %s; Text: %s", update.Message.From.UserName, update.Message.Text)
+	log.Printf("Update: %+v", update)
+	log.Printf("Message: %+v", update.Message)
+	log.Printf("Message.Chat: %+v", update.Message.Chat)
+	log.Printf("Message.NewChatMembers: %+v", update.Message.NewChatMembers)
+}
+
+// redactingLogger is passed to tgbotapi so that its messages do not expose secrets
+type redactingLogger struct {
+	secrets []string
+}
+
+func (l *redactingLogger) Println(v ...interface{}) {
+	log.Print(RedactSecrets(fmt.Sprintln(v...), l.secrets...))
+}
+
+func (l *redactingLogger) Printf(format string, v ...interface{}) {
+	log.Print(RedactSecrets(fmt.Sprintf(format, v...), l.secrets...))
+}
+
+// redact hides the token in errors of Telegram API, as network ones contain the request URL
+func (b *Bot) redact(err error) string {
+	return RedactSecrets(err.Error(), b.cfg.TGBot.Token)
+}
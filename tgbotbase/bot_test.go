@@ -0,0 +1,184 @@
+package tgbotbase
+
+import "testing"
+import "time"
+import "sync"
+import "strings"
+import "net/http"
+import "sync/atomic"
+import "io/ioutil"
+import "gopkg.in/telegram-bot-api.v4"
+
+// testTelegramTransport answers every Telegram API request with a message, remembering requested methods
+type testTelegramTransport struct {
+	mutex   sync.Mutex
+	methods []string
+}
+
+func (tr *testTelegramTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr.mutex.Lock()
+	tr.methods = append(tr.methods, req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:])
+	tr.mutex.Unlock()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`)),
+		Request:    req}, nil
+}
+
+func (tr *testTelegramTransport) count(method string) int {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	n := 0
+	for _, m := range tr.methods {
+		if m == method {
+			n++
+		}
+	}
+	return n
+}
+
+// testSlowReplier sends several replies some time after it has got a message
+type testSlowReplier struct {
+	BaseHandler
+	started chan struct{}
+	replies int
+}
+
+func (h *testSlowReplier) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- ServiceMsg) HandlerTrigger {
+	h.OutMsgCh = outMsgCh
+	return NewHandlerTrigger(nil, []string{"test"})
+}
+
+func (h *testSlowReplier) HandleOne(msg tgbotapi.Message) {
+	close(h.started)
+	time.Sleep(100 * time.Millisecond)
+	for i := 0; i < h.replies; i++ {
+		h.OutMsgCh <- tgbotapi.NewMessage(msg.Chat.ID, "reply")
+	}
+}
+
+func (h *testSlowReplier) Name() string {
+	return "slow replier"
+}
+
+func TestShutdownFlushesQueuedReplies(t *testing.T) {
+	cfg := Config{}
+	cfg.TGBot.SkipConnect = true
+	b := NewBot(cfg)
+	transport := &testTelegramTransport{}
+	api, err := tgbotapi.NewBotAPIWithClient("test", &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.bot = api
+	updates := make(chan tgbotapi.Update, 1)
+	b.botChannels.in_msg_chan = updates
+
+	handler := &testSlowReplier{started: make(chan struct{}), replies: 3}
+	b.AddHandler(NewIncomingMessageDealer(handler))
+
+	stopped := make(chan struct{})
+	go func() {
+		b.Start()
+		close(stopped)
+	}()
+
+	updates <- tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		Chat:      &tgbotapi.Chat{ID: 1},
+		Text:      "/test",
+		Entities:  &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 5}}}}
+	<-handler.started
+
+	b.Stop()
+	<-stopped
+	if err := b.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if sent := transport.count("sendMessage"); sent != handler.replies {
+		t.Fatal(sent, handler.replies)
+	}
+}
+
+// testProducer sends replies from its own goroutine until it is drained or quit
+type testProducer struct {
+	BaseHandler
+	sent   int32
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+func newTestProducer() *testProducer {
+	return &testProducer{stopCh: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (h *testProducer) Init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- ServiceMsg) {
+	h.OutMsgCh = outMsgCh
+}
+
+func (h *testProducer) Run() {
+	go func() {
+		defer close(h.done)
+		for {
+			select {
+			case h.OutMsgCh <- tgbotapi.NewMessage(1, "update"):
+				atomic.AddInt32(&h.sent, 1)
+				time.Sleep(5 * time.Millisecond)
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (h *testProducer) Name() string {
+	return "producer"
+}
+
+type testDrainableProducer struct {
+	*testProducer
+}
+
+func (h testDrainableProducer) Drain() <-chan struct{} {
+	close(h.stopCh)
+	return h.done
+}
+
+func TestShutdownWithProducingBackgroundHandlers(t *testing.T) {
+	cfg := Config{}
+	cfg.TGBot.SkipConnect = true
+	b := NewBot(cfg)
+	transport := &testTelegramTransport{}
+	api, err := tgbotapi.NewBotAPIWithClient("test", &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.bot = api
+	b.botChannels.in_msg_chan = make(chan tgbotapi.Update)
+
+	drainable := testDrainableProducer{newTestProducer()}
+	b.AddHandler(NewBackgroundMessageDealer(drainable))
+	// keeps sending after shutdown, which should not make the bot panic
+	rogue := newTestProducer()
+	b.AddHandler(NewBackgroundMessageDealer(rogue))
+	defer close(rogue.stopCh)
+
+	stopped := make(chan struct{})
+	go func() {
+		b.Start()
+		close(stopped)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	b.Stop()
+	<-stopped
+	if err := b.Shutdown(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // the rogue producer tries to send meanwhile
+
+	sent := int(atomic.LoadInt32(&drainable.sent) + atomic.LoadInt32(&rogue.sent))
+	if delivered := transport.count("sendMessage"); delivered != sent || sent == 0 {
+		t.Fatal(delivered, sent)
+	}
+}
@@ -0,0 +1,50 @@
+package tgbotbase
+
+import "fmt"
+import "strings"
+
+type Config struct {
+	TGBot struct {
+		Token       string
+		SkipConnect bool
+		Verbose     bool
+	}
+
+	Proxy_SOCKS5 struct {
+		Server string
+		User   string
+		Pass   string
+	}
+}
+
+// plainConfig has the same fields as Config but no String method, so it can be printed as is
+type plainConfig Config
+
+// String hides the token and the proxy password, so the config can be logged safely
+func (cfg Config) String() string {
+	cfg.TGBot.Token = MaskSecret(cfg.TGBot.Token)
+	cfg.Proxy_SOCKS5.Pass = MaskSecret(cfg.Proxy_SOCKS5.Pass)
+	return fmt.Sprintf("%+v", plainConfig(cfg))
+}
+
+// MaskedSecret is shown in logs instead of secrets
+const MaskedSecret = "******"
+
+// MaskSecret returns a placeholder for a non-empty secret; an empty one is kept so that it is seen as not set
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return MaskedSecret
+}
+
+// RedactSecrets replaces every occurrence of the secrets in text, e.g. in an error containing a request URL
+func RedactSecrets(text string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		text = strings.Replace(text, secret, MaskedSecret, -1)
+	}
+	return text
+}
@@ -0,0 +1,196 @@
+package tgbotbase
+
+import "testing"
+import "time"
+import "math/rand"
+import "sync/atomic"
+
+type testCronCountingJob struct {
+	count          int32
+	repeat         *time.Duration
+	repeatMaxCount int32
+}
+
+func (j *testCronCountingJob) Do(t time.Time, c Cron) {
+	atomic.AddInt32(&j.count, 1) // atomic to avoid race detector
+	if (j.repeat != nil) && j.count < j.repeatMaxCount {
+		c.AddJob(t.Add(*j.repeat), j)
+	}
+}
+
+func TestCallOnce(t *testing.T) {
+	c := NewCron()
+	j := &testCronCountingJob{}
+	c.AddJob(time.Now(), j)
+	time.Sleep(100 * time.Millisecond)
+	atomic.LoadInt32(&j.count)
+	if j.count != 1 {
+		t.Fatal(j.count)
+	}
+}
+
+func TestCallXTimes(t *testing.T) {
+	c := NewCron()
+	j := &testCronCountingJob{}
+
+	now := time.Now()
+	n := 5 + rand.Int31n(5)
+	var i int32
+	for ; i < n; i++ {
+		c.AddJob(now, j)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	atomic.LoadInt32(&j.count)
+	if j.count != n {
+		t.Fatal(j.count, n)
+	}
+}
+
+func TestDifferentTimesRandom(t *testing.T) {
+	durations := []int{1, 2, 3, 4, 5, 6, 7}
+	rand.Shuffle(len(durations), func(i int, j int) {
+		durations[i], durations[j] = durations[j], durations[i]
+	})
+
+	c := NewCron()
+	j := &testCronCountingJob{}
+	now := time.Now()
+	for i := 0; i < len(durations); i++ {
+		c.AddJob(now.Add(time.Duration(durations[i])*100*time.Millisecond), j)
+	}
+	time.Sleep(time.Duration(len(durations)+1) * 100 * time.Millisecond)
+	atomic.LoadInt32(&j.count)
+	if j.count != int32(len(durations)) {
+		t.Fatal(j.count, len(durations))
+	}
+}
+
+func TestDifferentTimesAsc(t *testing.T) {
+	durations := []int{1, 2, 3, 4, 5, 6, 7}
+
+	c := NewCron()
+	j := &testCronCountingJob{}
+	now := time.Now()
+	for i := 0; i < len(durations); i++ {
+		c.AddJob(now.Add(time.Duration(durations[i])*100*time.Millisecond), j)
+	}
+	time.Sleep(time.Duration(len(durations)+1) * 100 * time.Millisecond)
+	atomic.LoadInt32(&j.count)
+	if j.count != int32(len(durations)) {
+		t.Fatal(j.count, len(durations))
+	}
+}
+
+func TestDifferentTimesDesc(t *testing.T) {
+	durations := []int{7, 6, 5, 4, 3, 2, 1}
+
+	c := NewCron()
+	j := &testCronCountingJob{}
+	now := time.Now()
+	for i := 0; i < len(durations); i++ {
+		c.AddJob(now.Add(time.Duration(durations[i])*100*time.Millisecond), j)
+	}
+	time.Sleep(time.Duration(len(durations)+1) * 100 * time.Millisecond)
+	atomic.LoadInt32(&j.count)
+	if j.count != int32(len(durations)) {
+		t.Fatal(j.count, len(durations))
+	}
+}
+
+func TestRepeatXTimes(t *testing.T) {
+	c := NewCron()
+	repeat := 100 * time.Millisecond
+	repeatN := 3 + rand.Int31n(3)
+	j := &testCronCountingJob{
+		repeat:         &repeat,
+		repeatMaxCount: repeatN}
+
+	c.AddJob(time.Now(), j)
+
+	time.Sleep(time.Second)
+	atomic.LoadInt32(&j.count)
+	if j.count != repeatN {
+		t.Fatal(j.count, repeatN)
+	}
+}
+
+type testCronSlowJob struct {
+	started  chan struct{}
+	finished int32
+}
+
+func (j *testCronSlowJob) Do(t time.Time, c Cron) {
+	close(j.started)
+	time.Sleep(200 * time.Millisecond)
+	atomic.StoreInt32(&j.finished, 1)
+}
+
+func TestStopWaitsForRunningJobs(t *testing.T) {
+	c := NewCron()
+	j := &testCronSlowJob{started: make(chan struct{})}
+	c.AddJob(time.Now(), j)
+	<-j.started
+
+	if !c.Stop(time.Second) {
+		t.Fatal("running job has not finished within timeout")
+	}
+	if atomic.LoadInt32(&j.finished) != 1 {
+		t.Fatal("Stop has returned before the running job has finished")
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	c := NewCron()
+	j := &testCronSlowJob{started: make(chan struct{})}
+	c.AddJob(time.Now(), j)
+	<-j.started
+
+	if c.Stop(10 * time.Millisecond) {
+		t.Fatal("Stop has not noticed the job still running")
+	}
+}
+
+func TestAddJobAfterStopIsDropped(t *testing.T) {
+	c := NewCron()
+	c.Stop(time.Second)
+
+	j := &testCronCountingJob{}
+	added := make(chan struct{})
+	go func() {
+		c.AddJob(time.Now(), j)
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("AddJob has blocked after Stop")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if count := atomic.LoadInt32(&j.count); count != 0 {
+		t.Fatal(count)
+	}
+	if n := c.ScheduledJobs(); n != 0 {
+		t.Fatal(n)
+	}
+}
+
+type testCronCancelledJob struct {
+	testCronCountingJob
+}
+
+func (j *testCronCancelledJob) Cancelled() bool {
+	return true
+}
+
+func TestScheduledJobsSkipsCancelled(t *testing.T) {
+	c := NewCron()
+	later := time.Now().Add(time.Hour)
+	c.AddJob(later, &testCronCountingJob{})
+	c.AddJob(later, &testCronCancelledJob{})
+
+	if n := c.ScheduledJobs(); n != 1 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,228 @@
+package tgbotbase
+
+import "gopkg.in/telegram-bot-api.v4"
+import "regexp"
+import "log"
+import "strings"
+
+type ServiceMsg struct {
+	stopBot bool
+}
+
+// NewStopMsg creates a service message which makes the bot stop receiving updates and return from Start
+func NewStopMsg() ServiceMsg {
+	return ServiceMsg{stopBot: true}
+}
+
+type MessageDealer interface {
+	init(chan<- tgbotapi.Chattable, chan<- ServiceMsg)
+	accept(tgbotapi.Message)
+	run()
+	name() string
+}
+
+type HandlerTrigger struct {
+	re    *regexp.Regexp
+	cmds  map[string]bool
+	match func(tgbotapi.Message) bool
+}
+
+func NewHandlerTrigger(re *regexp.Regexp, cmds []string) HandlerTrigger {
+	cmdmap := make(map[string]bool, len(cmds))
+	for _, c := range cmds {
+		cmdmap[c] = true
+	}
+
+	return HandlerTrigger{re: re,
+		cmds: cmdmap}
+}
+
+// NewHandlerTriggerFunc additionally accepts messages for which match returns true, e.g. service messages without any text
+func NewHandlerTriggerFunc(re *regexp.Regexp, cmds []string, match func(tgbotapi.Message) bool) HandlerTrigger {
+	t := NewHandlerTrigger(re, cmds)
+	t.match = match
+	return t
+}
+
+func (t *HandlerTrigger) canHandle(msg tgbotapi.Message) bool {
+	text := strings.ToLower(msg.Text)
+	if t.re != nil && t.re.MatchString(text) {
+		log.Printf("Message text '%s' matched regexp '%s'", msg.Text, t.re)
+		return true
+	}
+	if t.match != nil && t.match(msg) {
+		log.Printf("Message %d in chat %d matched trigger function", msg.MessageID, msg.Chat.ID)
+		return true
+	}
+	if msg.IsCommand() {
+		cmd := msg.Command()
+		if _, found := t.cmds[cmd]; found {
+			log.Printf("Message text '%s' matched command '%s'", msg.Text, cmd)
+			return true
+		}
+	}
+	log.Printf("Message text '%s' doesn't match either commands '%v' or regexp '%s'", msg.Text, t.cmds, t.re)
+	return false
+}
+
+type IncomingMessageHandler interface {
+	Init(chan<- tgbotapi.Chattable, chan<- ServiceMsg) HandlerTrigger
+	HandleOne(tgbotapi.Message)
+	Name() string
+}
+
+type IncomingMessageDealer struct {
+	handler IncomingMessageHandler
+	trigger HandlerTrigger
+	inMsgCh chan tgbotapi.Message
+	done    chan struct{}
+}
+
+// drainer is implemented by dealers processing messages asynchronously
+type drainer interface {
+	drain() <-chan struct{} // no messages should be accepted after the call; returned channel is closed once the last one is processed
+}
+
+func NewIncomingMessageDealer(h IncomingMessageHandler) *IncomingMessageDealer {
+	d := &IncomingMessageDealer{handler: h}
+	return d
+}
+
+func (d *IncomingMessageDealer) init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- ServiceMsg) {
+	d.trigger = d.handler.Init(outMsgCh, srvCh)
+	d.inMsgCh = make(chan tgbotapi.Message, 0)
+	d.done = make(chan struct{})
+}
+
+func (d *IncomingMessageDealer) accept(msg tgbotapi.Message) {
+	if d.trigger.canHandle(msg) {
+		d.inMsgCh <- msg
+	}
+}
+
+func (d *IncomingMessageDealer) acceptCallback(query tgbotapi.CallbackQuery) (bool, *tgbotapi.CallbackConfig) {
+	h, ok := d.handler.(CallbackQueryHandler)
+	if !ok || !strings.HasPrefix(query.Data, h.CallbackPrefix()) {
+		return false, nil
+	}
+	return true, h.HandleCallback(query)
+}
+
+func (d *IncomingMessageDealer) run() {
+	go func() {
+		defer close(d.done)
+		for msg := range d.inMsgCh {
+			d.handler.HandleOne(msg)
+		}
+	}()
+}
+
+func (d *IncomingMessageDealer) drain() <-chan struct{} {
+	close(d.inMsgCh)
+	return d.done
+}
+
+func (d *IncomingMessageDealer) name() string {
+	return d.handler.Name()
+}
+
+type BaseHandler struct {
+	OutMsgCh chan<- tgbotapi.Chattable
+	SrvCh    chan<- ServiceMsg
+}
+
+type BackgroundMessageHandler interface {
+	Init(chan<- tgbotapi.Chattable, chan<- ServiceMsg)
+	Run()
+	Name() string
+}
+
+// DrainableBackgroundHandler is a background handler sending messages from its own goroutines, not only from cron jobs.
+// Drain is called on shutdown: the handler should stop producing new messages and close the returned channel
+// once the last one has been sent
+type DrainableBackgroundHandler interface {
+	BackgroundMessageHandler
+	Drain() <-chan struct{}
+}
+
+type BackgroundMessageDealer struct {
+	h BackgroundMessageHandler
+}
+
+func NewBackgroundMessageDealer(h BackgroundMessageHandler) MessageDealer {
+	return &BackgroundMessageDealer{h: h}
+}
+
+func (d *BackgroundMessageDealer) init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- ServiceMsg) {
+	d.h.Init(outMsgCh, srvCh)
+}
+
+func (d *BackgroundMessageDealer) accept(tgbotapi.Message) {
+	// doing nothing
+}
+
+func (d *BackgroundMessageDealer) run() {
+	d.h.Run()
+}
+
+func (d *BackgroundMessageDealer) name() string {
+	return d.h.Name()
+}
+
+func (d *BackgroundMessageDealer) drain() <-chan struct{} {
+	if h, ok := d.h.(DrainableBackgroundHandler); ok {
+		return h.Drain()
+	}
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+type inlineDealer interface {
+	acceptInline(tgbotapi.InlineQuery) *tgbotapi.InlineConfig
+}
+
+// InlineQueryHandler answers queries typed as '@bot text' in any chat
+type InlineQueryHandler interface {
+	Init(chan<- tgbotapi.Chattable, chan<- ServiceMsg)
+	HandleInline(tgbotapi.InlineQuery) *tgbotapi.InlineConfig // nil means that the query is not answered by this handler
+	Name() string
+}
+
+type InlineQueryDealer struct {
+	h InlineQueryHandler
+}
+
+func NewInlineQueryDealer(h InlineQueryHandler) MessageDealer {
+	return &InlineQueryDealer{h: h}
+}
+
+func (d *InlineQueryDealer) init(outMsgCh chan<- tgbotapi.Chattable, srvCh chan<- ServiceMsg) {
+	d.h.Init(outMsgCh, srvCh)
+}
+
+func (d *InlineQueryDealer) accept(tgbotapi.Message) {
+	// doing nothing
+}
+
+func (d *InlineQueryDealer) acceptInline(query tgbotapi.InlineQuery) *tgbotapi.InlineConfig {
+	return d.h.HandleInline(query)
+}
+
+func (d *InlineQueryDealer) run() {
+	// doing nothing
+}
+
+func (d *InlineQueryDealer) name() string {
+	return d.h.Name()
+}
+
+type callbackDealer interface {
+	acceptCallback(tgbotapi.CallbackQuery) (bool, *tgbotapi.CallbackConfig)
+}
+
+// CallbackQueryHandler can be additionally implemented by IncomingMessageHandler to receive presses of inline keyboard buttons it has sent
+type CallbackQueryHandler interface {
+	CallbackPrefix() string                                         // only queries with data starting with the prefix are passed to the handler
+	HandleCallback(tgbotapi.CallbackQuery) *tgbotapi.CallbackConfig // nil answers the query without any notification
+}
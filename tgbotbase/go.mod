@@ -0,0 +1,11 @@
+module github.com/admirallarimda/tgbotbase
+
+go 1.13
+
+require (
+	github.com/go-redis/redis v6.15.7+incompatible
+	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible // indirect
+	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
+	golang.org/x/net v0.0.0-20200301022130-244492dfa37a
+	gopkg.in/telegram-bot-api.v4 v4.6.4
+)
@@ -0,0 +1,21 @@
+package tgbotbase
+
+type PropertyValue struct {
+	Name  string
+	Value string
+	User  UserID
+	Chat  ChatID
+}
+
+type PropertyStorage interface {
+	GetProperty(name string, user UserID, chat ChatID) (string, error)
+	SetPropertyForUser(name string, user UserID, value interface{}) error
+	SetPropertyForChat(name string, chat ChatID, value interface{}) error
+	SetPropertyForUserInChat(name string, user UserID, chat ChatID, value interface{}) error
+	GetEveryHavingProperty(name string) ([]PropertyValue, error)
+	// GetPropertiesForUserInChat returns every value visible for the user in the chat: user-in-chat, user-wide and chat-wide ones
+	GetPropertiesForUserInChat(user UserID, chat ChatID) ([]PropertyValue, error)
+	// GetPropertiesForChat returns values of every user (including chat-wide ones with zero user) set in the chat
+	GetPropertiesForChat(chat ChatID) ([]PropertyValue, error)
+	DeletePropertyForUserInChat(name string, user UserID, chat ChatID) error
+}
@@ -0,0 +1,184 @@
+package tgbotbase
+
+import "log"
+import "fmt"
+import "strings"
+import "strconv"
+import "github.com/go-redis/redis"
+
+type RedisPropertyStorage struct {
+	client *redis.Client
+}
+
+func NewRedisPropertyStorage(pool RedisPool) *RedisPropertyStorage {
+	r := &RedisPropertyStorage{client: pool.GetConnByName("property")}
+	return r
+}
+
+func redisPropertyKey(name string, user UserID, chat ChatID) string {
+	return fmt.Sprintf("tg:property:%s:%d:%d", name, user, chat)
+}
+
+func (r *RedisPropertyStorage) SetPropertyForUserInChat(name string, user UserID, chat ChatID, value interface{}) error {
+	log.Printf("Setting property '%s' for user %d chat %d with value: %v", name, user, chat, value)
+	key := redisPropertyKey(name, user, chat)
+	return r.client.Set(key, value, 0).Err()
+}
+
+func (r *RedisPropertyStorage) SetPropertyForUser(name string, user UserID, value interface{}) error {
+	log.Printf("Setting property '%s' for user %d with value: %v", name, user, value)
+	return r.SetPropertyForUserInChat(name, user, ChatID(user), value)
+}
+
+func (r *RedisPropertyStorage) SetPropertyForChat(name string, chat ChatID, value interface{}) error {
+	log.Printf("Setting property '%s' for chat %d with value: %v", name, chat, value)
+	return r.SetPropertyForUserInChat(name, 0, chat, value)
+}
+
+func (r *RedisPropertyStorage) GetProperty(name string, user UserID, chat ChatID) (string, error) {
+	log.Printf("Getting property '%s' for user %d chat %d", name, user, chat)
+
+	// checking specific property value for this user in this chat
+	res := r.client.Get(redisPropertyKey(name, user, chat))
+	err := res.Err()
+	if err != nil {
+		if err == redis.Nil {
+			log.Printf("No property '%s' for user %d chat %d, checking next", name, user, chat)
+		} else {
+			return "", err
+		}
+	} else {
+		return res.Val(), nil
+	}
+
+	// checking user-defined property (for any chat, set via direct msg)
+	res = r.client.Get(redisPropertyKey(name, user, ChatID(user)))
+	err = res.Err()
+	if err != nil {
+		if err == redis.Nil {
+			log.Printf("No property '%s' for user %d, checking next", name, user)
+		} else {
+			return "", err
+		}
+	} else {
+		return res.Val(), nil
+	}
+
+	// checking chat-defined property (default property for this chat)
+	res = r.client.Get(redisPropertyKey(name, 0, chat))
+	err = res.Err()
+	if err != nil {
+		if err == redis.Nil {
+			log.Printf("No property '%s' for chat %d", name, chat)
+		} else {
+			return "", err
+		}
+	} else {
+		return res.Val(), nil
+	}
+
+	log.Printf("No property '%s' for user %d chat %d, returning null", name, user, chat)
+	return "", nil
+}
+
+func (r *RedisPropertyStorage) GetEveryHavingProperty(name string) ([]PropertyValue, error) {
+	log.Printf("Getting property '%s' for every chat", name)
+	pattern := fmt.Sprintf("tg:property:%s:*:*", name)
+	keys, err := GetAllKeys(r.client, pattern)
+	if err != nil {
+		return nil, err
+	}
+	props := make([]PropertyValue, 0, len(keys))
+	for _, k := range keys {
+		value, err := r.client.Get(k).Result()
+		if err != nil {
+			log.Printf("Property by key '%s' could not be retrieved due to error: %s", k, err)
+			continue
+		}
+
+		prop, err := parseRedisPropertyKey(k)
+		if err != nil {
+			log.Printf("Key '%s' could not be parsed due to error: %s", k, err)
+			continue
+		}
+		prop.Value = value
+		props = append(props, prop)
+	}
+
+	return props, nil
+}
+
+func (r *RedisPropertyStorage) GetPropertiesForUserInChat(user UserID, chat ChatID) ([]PropertyValue, error) {
+	log.Printf("Getting all properties for user %d chat %d", user, chat)
+	return r.getPropertiesByPatterns(
+		fmt.Sprintf("tg:property:*:%d:%d", user, chat),
+		fmt.Sprintf("tg:property:*:%d:%d", user, user),
+		fmt.Sprintf("tg:property:*:%d:%d", 0, chat))
+}
+
+func (r *RedisPropertyStorage) GetPropertiesForChat(chat ChatID) ([]PropertyValue, error) {
+	log.Printf("Getting all properties for chat %d", chat)
+	return r.getPropertiesByPatterns(fmt.Sprintf("tg:property:*:*:%d", chat))
+}
+
+func (r *RedisPropertyStorage) getPropertiesByPatterns(patterns ...string) ([]PropertyValue, error) {
+	keys := make([]string, 0)
+	for _, pattern := range patterns {
+		found, err := GetAllKeys(r.client, pattern)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, found...)
+	}
+	keys = uniqueStringSlice(keys)
+
+	props := make([]PropertyValue, 0, len(keys))
+	for _, k := range keys {
+		value, err := r.client.Get(k).Result()
+		if err != nil {
+			if err == redis.Nil {
+				continue
+			}
+			return nil, err
+		}
+		prop, err := parseRedisPropertyKey(k)
+		if err != nil {
+			log.Printf("Key '%s' could not be parsed due to error: %s", k, err)
+			continue
+		}
+		prop.Value = value
+		props = append(props, prop)
+	}
+	return props, nil
+}
+
+func (r *RedisPropertyStorage) DeletePropertyForUserInChat(name string, user UserID, chat ChatID) error {
+	log.Printf("Deleting property '%s' for user %d chat %d", name, user, chat)
+	return r.client.Del(redisPropertyKey(name, user, chat)).Err()
+}
+
+// parseRedisPropertyKey splits 'tg:property:<name>:<user>:<chat>' into its parts, value is left empty
+func parseRedisPropertyKey(key string) (PropertyValue, error) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 5 {
+		return PropertyValue{}, fmt.Errorf("unexpected number of parts %d", len(parts))
+	}
+	userStr := parts[3]
+	userID, err := strconv.Atoi(userStr)
+	if err != nil {
+		return PropertyValue{}, fmt.Errorf("could not convert user '%s' to integer: %s", userStr, err)
+	}
+
+	chatStr := parts[4]
+	chatID, err := strconv.ParseInt(chatStr, 10, 64)
+	if err != nil {
+		return PropertyValue{}, fmt.Errorf("could not convert chat '%s' to integer: %s", chatStr, err)
+	}
+
+	return PropertyValue{
+		Name: parts[2],
+		User: UserID(userID),
+		Chat: ChatID(chatID)}, nil
+}
+
+var _ PropertyStorage = &RedisPropertyStorage{}
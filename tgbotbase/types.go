@@ -0,0 +1,4 @@
+package tgbotbase
+
+type UserID int
+type ChatID int64
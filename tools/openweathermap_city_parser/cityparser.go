@@ -1,49 +1,171 @@
 package main
 
-import "fmt"
-import "log"
-import "strings"
-import "io/ioutil"
-import "encoding/json"
-import "github.com/go-redis/redis"
-
-const city_file = "city.list.json"
-const redis_addr = "localhost:6379"
-const redis_db = 0 // common db with settings shared between bots
-
-type cityInfo struct {
-    ID int64    `json:"id"`
-    Name string `json:"name"`
-    // there's something else but we don't need it
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/go-redis/redis"
+	"github.com/ilyalavrinov/tgbot-betterthanpbelov/citylist"
+)
+
+var (
+	input      = flag.String("input", "city.list.json", "path to city list from OpenWeatherMap, either .json or .json.gz")
+	redisAddr  = flag.String("redis-addr", "localhost:6379", "Redis server address")
+	redisDB    = flag.Int("redis-db", 0, "Redis DB; common db with settings shared between bots by default")
+	redisPass  = flag.String("redis-pass", "", "Redis password")
+	keyPrefix  = flag.String("prefix", citylist.DefaultRedisPrefix, "prefix of all keys written to Redis; the bot reads it from [weather] cities-prefix")
+	batchSize  = flag.Int("batch", 1000, "number of cities written to Redis in one pipeline")
+	progressAt = flag.Int("progress", 10000, "report progress every N cities, 0 turns reporting off")
+	keepOld    = flag.Int("keep", 1, "number of previous index versions kept for rollback")
+	gcLegacy   = flag.Bool("gc-legacy", false, "remove keys of the index written before versioning was introduced")
+	diffMode   = flag.Bool("diff", false, "do not import, only compare the file with the active index and validate changes")
+	maxRemoved = flag.Float64("max-removed", 5, "percent of removed cities which makes the change suspicious in diff mode")
+	examples   = flag.Int("examples", 20, "number of examples printed for every kind of change in diff mode")
+)
+
+// batchWriter accumulates Redis commands in a pipeline and executes them every batchSize items
+type batchWriter struct {
+	pipe    redis.Pipeliner
+	size    int
+	pending int
+	written int
+	failed  int
+}
+
+func newBatchWriter(conn *redis.Client, size int) *batchWriter {
+	return &batchWriter{pipe: conn.Pipeline(), size: size}
+}
+
+func (w *batchWriter) added() {
+	w.pending++
+	if w.pending >= w.size {
+		w.flush()
+	}
+}
+
+func (w *batchWriter) flush() {
+	if w.pending == 0 {
+		return
+	}
+	cmds, err := w.pipe.Exec()
+	if err != nil {
+		for _, cmd := range cmds {
+			if cmd.Err() != nil {
+				w.failed++
+				log.Printf("Redis command %v failed due to error: %s", cmd.Args(), cmd.Err())
+			}
+		}
+	}
+	w.written += w.pending
+	w.pending = 0
+}
+
+// progressReached tells whether progress should be reported after n items
+func progressReached(n int) bool {
+	return *progressAt > 0 && n > 0 && n%*progressAt == 0
 }
 
 func main() {
-    content, err := ioutil.ReadFile(city_file)
-    if err != nil {
-        log.Fatalf("Could not read contents of file '%s' due to error: %s", city_file, err)
-    }
-    cities := make([]cityInfo, 0, 250000)
-    err = json.Unmarshal(content, &cities)
-    if err != nil {
-        log.Fatalf("Could not unmarshal json due to error: %s", err)
-    }
-    log.Printf("Parsed json contains %d records", len(cities))
-    if len(cities) == 0 {
-        panic("Zero cities")
-    }
-
-    log.Printf("Connecting to Redis (%s DB: %d)...", redis_addr, redis_db)
-    opts := &redis.Options {
-        Addr: redis_addr,
-        DB: redis_db }
-    conn := redis.NewClient(opts)
-    log.Printf("Redis connected")
-    for _, city := range cities {
-        key := fmt.Sprintf("openweathermap:city:%s", strings.ToLower(city.Name))
-        err = conn.HSet(key, "id", city.ID).Err()
-        if err != nil {
-            log.Printf("Could not store info about city %s (ID: %d)", city.Name, city.ID)
-        }
-    }
-    log.Printf("File parsing and saving has been finished")
+	flag.Parse()
+	if *batchSize < 1 {
+		log.Fatalf("Batch size should be positive, got %d", *batchSize)
+	}
+	if *progressAt < 0 {
+		log.Fatalf("Progress interval should not be negative, got %d", *progressAt)
+	}
+
+	in, err := citylist.Open(*input)
+	if err != nil {
+		log.Fatalf("Could not open file '%s' due to error: %s", *input, err)
+	}
+	defer in.Close()
+
+	log.Printf("Connecting to Redis (%s DB: %d)...", *redisAddr, *redisDB)
+	opts := &redis.Options{
+		Addr:     *redisAddr,
+		Password: *redisPass,
+		DB:       *redisDB}
+	conn := redis.NewClient(opts)
+	if err := conn.Ping().Err(); err != nil {
+		log.Fatalf("Could not connect to Redis due to error: %s", err)
+	}
+	log.Printf("Redis connected")
+
+	if *diffMode {
+		if !runDiff(conn, in, *keyPrefix, *maxRemoved, *examples) {
+			os.Exit(2)
+		}
+		return
+	}
+
+	index := newCityIndex(*keyPrefix, newIndexVersion())
+	if err := index.register(conn); err != nil {
+		log.Fatalf("Could not register new index version due to error: %s", err)
+	}
+	log.Printf("Writing new city index version %s", index.version)
+	// an incomplete version left registered would be kept by garbage collection as a rollback instead of the real previous one
+	fail := func(format string, args ...interface{}) {
+		log.Printf(format, args...)
+		index.drop(conn)
+		os.Exit(1)
+	}
+
+	writer := newBatchWriter(conn, *batchSize)
+	resolver := citylist.NewNameResolver()
+	ids := make(map[int64]bool, 250000)
+	count := 0
+	err = citylist.Read(in, func(city citylist.City) error {
+		writer.pipe.HMSet(index.idKey(city.ID), map[string]interface{}{
+			"name":       city.Name,
+			"state":      city.State,
+			"country":    city.Country,
+			"lat":        city.Coord.Lat,
+			"lon":        city.Coord.Lon,
+			"population": city.Stat.Population})
+		writer.pipe.GeoAdd(index.geoKey(), &redis.GeoLocation{
+			Name:      fmt.Sprintf("%d", city.ID),
+			Longitude: city.Coord.Lon,
+			Latitude:  city.Coord.Lat})
+		writer.added()
+		resolver.Add(city)
+		ids[city.ID] = true
+
+		count++
+		if progressReached(count) {
+			log.Printf("Processed %d cities", count)
+		}
+		return nil
+	})
+	if err != nil {
+		fail("Could not parse city list after %d cities due to error: %s", count, err)
+	}
+	writer.flush()
+	log.Printf("Parsed %d cities", count)
+	if count == 0 {
+		fail("Zero cities")
+	}
+
+	log.Printf("Writing %d names, %d name collisions", len(resolver.Owners), resolver.Collisions)
+	for name, owner := range resolver.Owners {
+		writer.pipe.HSet(index.nameKey(name), "id", owner.ID)
+		writer.added()
+		if writer.pending == 0 && progressReached(writer.written) {
+			log.Printf("Written %d records", writer.written)
+		}
+	}
+	writer.flush()
+
+	log.Printf("File parsing and saving has been finished; %d records written, %d commands failed", writer.written, writer.failed)
+
+	if err := index.verify(conn, len(ids), len(resolver.Owners)); err != nil {
+		fail("New index version %s is inconsistent, dropping it: %s", index.version, err)
+	}
+	if err := index.activate(conn); err != nil {
+		fail("Could not activate index version %s due to error: %s", index.version, err)
+	}
+	log.Printf("Index version %s is active now", index.version)
+
+	index.collectGarbage(conn, *keepOld, *gcLegacy)
 }
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"github.com/ilyalavrinov/tgbot-betterthanpbelov/citylist"
+)
+
+// indexSnapshot is what the bot can resolve: every name points to a single city ID.
+// Cities are kept by ID, as several cities may share a name
+type indexSnapshot struct {
+	nameToID map[string]int64
+	cities   map[int64]string // primary name of every city
+	legacy   bool             // the index has no city records, so cities and their names are taken from the name index
+}
+
+func newIndexSnapshot() *indexSnapshot {
+	return &indexSnapshot{
+		nameToID: make(map[string]int64, 250000),
+		cities:   make(map[int64]string, 250000)}
+}
+
+// activeIndexPrefix returns key prefix of the index version currently used by the bot
+func activeIndexPrefix(conn *redis.Client, prefix string) (string, error) {
+	version, err := conn.Get(newCityIndex(prefix, "").activeVersionKey()).Result()
+	if err == redis.Nil {
+		log.Printf("No active index version, comparing with the legacy index")
+		return prefix, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Comparing with active index version %s", version)
+	return newCityIndex(prefix, version).versionPrefix(), nil
+}
+
+// scanHashField calls f with the value of the field of every hash matching the pattern
+func scanHashField(conn *redis.Client, pattern string, field string, f func(key string, value string)) error {
+	var cursor uint64
+	for {
+		keys, next, err := conn.Scan(cursor, pattern, 1000).Result()
+		if err != nil {
+			return err
+		}
+
+		pipe := conn.Pipeline()
+		cmds := make([]*redis.StringCmd, len(keys))
+		for i, k := range keys {
+			cmds[i] = pipe.HGet(k, field)
+		}
+		if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+			return err
+		}
+		for i, k := range keys {
+			value, err := cmds[i].Result()
+			if err != nil {
+				log.Printf("Could not get '%s' by key '%s' due to error: %s", field, k, err)
+				continue
+			}
+			f(k, value)
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// loadIndexSnapshot reads all cities and names of the index with the given prefix
+func loadIndexSnapshot(conn *redis.Client, prefix string) (*indexSnapshot, error) {
+	snapshot := newIndexSnapshot()
+	namePrefix := prefix + ":city:"
+	err := scanHashField(conn, namePrefix+"*", "id", func(key string, value string) {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Printf("Could not parse city ID '%s' by key '%s' due to error: %s", value, key, err)
+			return
+		}
+		snapshot.nameToID[strings.TrimPrefix(key, namePrefix)] = id
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	idPrefix := prefix + ":cityid:"
+	err = scanHashField(conn, idPrefix+"*", "name", func(key string, value string) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(key, idPrefix), 10, 64)
+		if err != nil {
+			log.Printf("Could not parse city ID of key '%s' due to error: %s", key, err)
+			return
+		}
+		snapshot.cities[id] = strings.ToLower(value)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshot.cities) == 0 {
+		// the legacy index has names only, so cities are known only by the names resolving to them
+		snapshot.legacy = true
+		for name, id := range snapshot.nameToID {
+			if _, found := snapshot.cities[id]; !found || name < snapshot.cities[id] {
+				snapshot.cities[id] = name
+			}
+		}
+	}
+	return snapshot, nil
+}
+
+type cityDiff struct {
+	cities     int
+	added      []string
+	removed    []string
+	renamed    []string
+	repointed  []string // names which will resolve to another city
+	collisions []string // names shared by several cities in the new list
+}
+
+// diffCities compares the new city list with the currently loaded index
+func diffCities(r io.Reader, current *indexSnapshot) (*cityDiff, error) {
+	diff := &cityDiff{}
+	resolver := citylist.NewNameResolver()
+	primary := make(map[int64]string, 250000)
+	nameIDs := make(map[string]map[int64]bool, 250000)
+	err := citylist.Read(r, func(city citylist.City) error {
+		diff.cities++
+		resolver.Add(city)
+		primary[city.ID] = strings.ToLower(city.Name)
+		for _, name := range city.Names() {
+			if nameIDs[name] == nil {
+				nameIDs[name] = make(map[int64]bool, 1)
+			}
+			nameIDs[name][city.ID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for id, name := range primary {
+		currentName, found := current.cities[id]
+		if !found {
+			diff.added = append(diff.added, fmt.Sprintf("%d %s", id, name))
+		} else if !current.legacy && currentName != name {
+			diff.renamed = append(diff.renamed, fmt.Sprintf("%d %s -> %s", id, currentName, name))
+		}
+	}
+	for id, name := range current.cities {
+		if _, found := primary[id]; !found {
+			diff.removed = append(diff.removed, fmt.Sprintf("%d %s", id, name))
+		}
+	}
+	for name, id := range current.nameToID {
+		if owner, found := resolver.Owners[name]; found && owner.ID != id {
+			diff.repointed = append(diff.repointed, fmt.Sprintf("%s: %d -> %d", name, id, owner.ID))
+		}
+	}
+	for name, ids := range nameIDs {
+		if len(ids) < 2 {
+			continue
+		}
+		idStrs := make([]string, 0, len(ids))
+		for id := range ids {
+			idStrs = append(idStrs, strconv.FormatInt(id, 10))
+		}
+		sort.Strings(idStrs)
+		diff.collisions = append(diff.collisions, fmt.Sprintf("%s: %s (resolved to %d)", name, strings.Join(idStrs, ", "), resolver.Owners[name].ID))
+	}
+
+	for _, list := range [][]string{diff.added, diff.removed, diff.renamed, diff.repointed, diff.collisions} {
+		sort.Strings(list)
+	}
+	return diff, nil
+}
+
+func printDiffSection(title string, entries []string, examples int) {
+	fmt.Printf("%s: %d\n", title, len(entries))
+	for i, e := range entries {
+		if i >= examples {
+			fmt.Printf("  ... and %d more\n", len(entries)-examples)
+			break
+		}
+		fmt.Printf("  %s\n", e)
+	}
+}
+
+func (d *cityDiff) print(currentCities int, examples int) {
+	fmt.Printf("Cities in file: %d, in current index: %d\n", d.cities, currentCities)
+	printDiffSection("Added", d.added, examples)
+	printDiffSection("Removed", d.removed, examples)
+	printDiffSection("Renamed", d.renamed, examples)
+	printDiffSection("Names resolving to another city", d.repointed, examples)
+	printDiffSection("Name collisions in file", d.collisions, examples)
+}
+
+// validate returns the list of reasons why the import looks suspicious
+func (d *cityDiff) validate(currentCities int, maxRemovedPercent float64) []string {
+	problems := make([]string, 0)
+	if d.cities == 0 {
+		problems = append(problems, "file contains zero cities")
+	}
+	if currentCities > 0 {
+		removed := 100 * float64(len(d.removed)) / float64(currentCities)
+		if removed > maxRemovedPercent {
+			problems = append(problems, fmt.Sprintf("%.1f%% of current cities would be removed (limit %.1f%%)", removed, maxRemovedPercent))
+		}
+	}
+	return problems
+}
+
+// runDiff compares the file with the active index and reports changes; returns false if changes look suspicious
+func runDiff(conn *redis.Client, in io.Reader, prefix string, maxRemovedPercent float64, examples int) bool {
+	activePrefix, err := activeIndexPrefix(conn, prefix)
+	if err != nil {
+		log.Fatalf("Could not get active index version due to error: %s", err)
+	}
+	current, err := loadIndexSnapshot(conn, activePrefix)
+	if err != nil {
+		log.Fatalf("Could not load current index due to error: %s", err)
+	}
+	log.Printf("Current index contains %d names of %d cities", len(current.nameToID), len(current.cities))
+
+	diff, err := diffCities(in, current)
+	if err != nil {
+		log.Fatalf("Could not parse city list due to error: %s", err)
+	}
+	diff.print(len(current.cities), examples)
+
+	problems := diff.validate(len(current.cities), maxRemovedPercent)
+	for _, p := range problems {
+		fmt.Printf("SUSPICIOUS: %s\n", p)
+	}
+	return len(problems) == 0
+}
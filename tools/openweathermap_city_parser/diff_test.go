@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+import "reflect"
+import "strings"
+
+// testSnapshot builds an index as the city parser writes it: every city is known by its primary name
+func testSnapshot(cities map[int64]string) *indexSnapshot {
+	snapshot := newIndexSnapshot()
+	for id, name := range cities {
+		snapshot.cities[id] = name
+		snapshot.nameToID[name] = id
+	}
+	return snapshot
+}
+
+func TestDiffCities(t *testing.T) {
+	cases := []struct {
+		name     string
+		current  *indexSnapshot
+		file     string
+		added    []string
+		removed  []string
+		renamed  []string
+		problems int
+	}{
+		{
+			name:    "added",
+			current: testSnapshot(map[int64]string{1: "kazan"}),
+			file:    `[{"id":1,"name":"Kazan"},{"id":2,"name":"Moscow"}]`,
+			added:   []string{"2 moscow"}},
+		{
+			name:    "removed",
+			current: testSnapshot(map[int64]string{1: "kazan", 2: "moscow", 3: "omsk"}),
+			file:    `[{"id":1,"name":"Kazan"},{"id":2,"name":"Moscow"}]`,
+			removed: []string{"3 omsk"}},
+		{
+			name:    "renamed",
+			current: testSnapshot(map[int64]string{1: "kazan", 2: "gorky"}),
+			file:    `[{"id":1,"name":"Kazan"},{"id":2,"name":"Nizhny Novgorod"}]`,
+			renamed: []string{"2 gorky -> nizhny novgorod"}},
+		{
+			name: "renames are unknown for legacy index",
+			current: func() *indexSnapshot {
+				s := testSnapshot(map[int64]string{1: "kazan", 2: "gorky"})
+				s.legacy = true
+				return s
+			}(),
+			file: `[{"id":1,"name":"Kazan"},{"id":2,"name":"Nizhny Novgorod"}]`},
+		{
+			name:     "empty input",
+			current:  testSnapshot(map[int64]string{}),
+			file:     `[]`,
+			problems: 1},
+		{
+			name:     "mass deletion",
+			current:  testSnapshot(map[int64]string{1: "kazan", 2: "moscow", 3: "omsk", 4: "tver"}),
+			file:     `[{"id":1,"name":"Kazan"}]`,
+			removed:  []string{"2 moscow", "3 omsk", "4 tver"},
+			problems: 1},
+		{
+			name:     "empty input removes everything",
+			current:  testSnapshot(map[int64]string{1: "kazan"}),
+			file:     `[]`,
+			removed:  []string{"1 kazan"},
+			problems: 2},
+	}
+
+	for _, c := range cases {
+		diff, err := diffCities(strings.NewReader(c.file), c.current)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		for _, list := range []struct {
+			title    string
+			actual   []string
+			expected []string
+		}{{"added", diff.added, c.added}, {"removed", diff.removed, c.removed}, {"renamed", diff.renamed, c.renamed}} {
+			if len(list.actual) != 0 || len(list.expected) != 0 {
+				if !reflect.DeepEqual(list.actual, list.expected) {
+					t.Errorf("%s: %s %v, expected %v", c.name, list.title, list.actual, list.expected)
+				}
+			}
+		}
+		if problems := diff.validate(len(c.current.cities), 50); len(problems) != c.problems {
+			t.Errorf("%s: problems %v, expected %d", c.name, problems, c.problems)
+		}
+	}
+}
+
+func TestDiffCitiesRepointedAndCollisions(t *testing.T) {
+	current := testSnapshot(map[int64]string{1: "kazan"})
+	file := `[{"id":1,"name":"Kazan","stat":{"population":1000}},{"id":2,"name":"Kazan","stat":{"population":5000}}]`
+	diff, err := diffCities(strings.NewReader(file), current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diff.repointed, []string{"kazan: 1 -> 2"}) {
+		t.Errorf("repointed %v", diff.repointed)
+	}
+	if !reflect.DeepEqual(diff.collisions, []string{"kazan: 1, 2 (resolved to 2)"}) {
+		t.Errorf("collisions %v", diff.collisions)
+	}
+}
+
+func TestDiffCitiesInvalidInput(t *testing.T) {
+	if _, err := diffCities(strings.NewReader(`{"id":1}`), testSnapshot(nil)); err == nil {
+		t.Fatal("not an array has been accepted")
+	}
+}
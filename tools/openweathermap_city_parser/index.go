@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// cityIndex is a single version of the city index; the bot reads the version which is stored by activeVersionKey
+type cityIndex struct {
+	prefix  string
+	version string
+}
+
+func newIndexVersion() string {
+	return time.Now().UTC().Format("20060102150405")
+}
+
+func newCityIndex(prefix string, version string) cityIndex {
+	return cityIndex{prefix: prefix, version: version}
+}
+
+func (i cityIndex) versionPrefix() string {
+	return fmt.Sprintf("%s:v%s", i.prefix, i.version)
+}
+
+func (i cityIndex) nameKey(name string) string {
+	return fmt.Sprintf("%s:city:%s", i.versionPrefix(), name)
+}
+
+func (i cityIndex) idKey(id int64) string {
+	return fmt.Sprintf("%s:cityid:%d", i.versionPrefix(), id)
+}
+
+func (i cityIndex) geoKey() string {
+	return fmt.Sprintf("%s:citygeo", i.versionPrefix())
+}
+
+func (i cityIndex) activeVersionKey() string {
+	return fmt.Sprintf("%s:city-version", i.prefix)
+}
+
+func (i cityIndex) versionsKey() string {
+	return fmt.Sprintf("%s:city-versions", i.prefix)
+}
+
+// register remembers the version before writing it, so it is garbage-collected even if the import fails
+func (i cityIndex) register(conn *redis.Client) error {
+	return conn.SAdd(i.versionsKey(), i.version).Err()
+}
+
+func countKeys(conn *redis.Client, pattern string) (int, error) {
+	count := 0
+	var cursor uint64
+	for {
+		keys, next, err := conn.Scan(cursor, pattern, 1000).Result()
+		if err != nil {
+			return 0, err
+		}
+		count += len(keys)
+		cursor = next
+		if cursor == 0 {
+			return count, nil
+		}
+	}
+}
+
+// verify checks that all cities and names have been written
+func (i cityIndex) verify(conn *redis.Client, cities int, names int) error {
+	storedCities, err := countKeys(conn, fmt.Sprintf("%s:cityid:*", i.versionPrefix()))
+	if err != nil {
+		return err
+	}
+	if storedCities != cities {
+		return fmt.Errorf("%d cities parsed, but %d stored", cities, storedCities)
+	}
+
+	storedNames, err := countKeys(conn, fmt.Sprintf("%s:city:*", i.versionPrefix()))
+	if err != nil {
+		return err
+	}
+	if storedNames != names {
+		return fmt.Errorf("%d names resolved, but %d stored", names, storedNames)
+	}
+	return nil
+}
+
+// activate atomically switches the bot to this version
+func (i cityIndex) activate(conn *redis.Client) error {
+	return conn.Set(i.activeVersionKey(), i.version, 0).Err()
+}
+
+func deleteByPattern(conn *redis.Client, pattern string) int {
+	deleted := 0
+	var cursor uint64
+	for {
+		keys, next, err := conn.Scan(cursor, pattern, 1000).Result()
+		if err != nil {
+			log.Printf("Could not scan keys '%s' due to error: %s", pattern, err)
+			return deleted
+		}
+		if len(keys) > 0 {
+			if err := conn.Del(keys...).Err(); err != nil {
+				log.Printf("Could not delete keys '%s' due to error: %s", pattern, err)
+			} else {
+				deleted += len(keys)
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return deleted
+		}
+	}
+}
+
+// drop removes all keys of this version
+func (i cityIndex) drop(conn *redis.Client) {
+	deleted := deleteByPattern(conn, fmt.Sprintf("%s:*", i.versionPrefix()))
+	conn.SRem(i.versionsKey(), i.version)
+	log.Printf("Index version %s has been dropped, %d keys deleted", i.version, deleted)
+}
+
+// collectGarbage removes all versions except the active one and 'keep' previous ones
+func (i cityIndex) collectGarbage(conn *redis.Client, keep int, legacy bool) {
+	active, err := conn.Get(i.activeVersionKey()).Result()
+	if err != nil {
+		log.Printf("Could not get active index version, skipping garbage collection: %s", err)
+		return
+	}
+	versions, err := conn.SMembers(i.versionsKey()).Result()
+	if err != nil {
+		log.Printf("Could not get known index versions, skipping garbage collection: %s", err)
+		return
+	}
+
+	// versions are timestamps, so newer ones go first after reverse sorting
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+	kept := 0
+	for _, v := range versions {
+		if v == active {
+			continue
+		}
+		if v < active && kept < keep {
+			kept++
+			continue
+		}
+		if v > active {
+			// might be an import running right now
+			continue
+		}
+		newCityIndex(i.prefix, v).drop(conn)
+	}
+
+	if legacy {
+		deleted := 0
+		for _, pattern := range []string{"%s:city:*", "%s:cityid:*", "%s:citygeo"} {
+			deleted += deleteByPattern(conn, fmt.Sprintf(pattern, i.prefix))
+		}
+		log.Printf("Legacy index has been removed, %d keys deleted", deleted)
+	}
+}